@@ -18,8 +18,9 @@ package main
 
 import (
 	"context"
-	"flag"
 	"fmt"
+	"github.com/Burmuley/priority-pubsub/filter"
+	"github.com/Burmuley/priority-pubsub/ingress"
 	"github.com/Burmuley/priority-pubsub/poll"
 	"github.com/Burmuley/priority-pubsub/process"
 	"github.com/Burmuley/priority-pubsub/queue"
@@ -29,15 +30,7 @@ import (
 	"github.com/knadh/koanf/v2"
 )
 
-func getPollLaunchConfig() (*poll.LaunchConfig, error) {
-	var queueConfig []any
-	var processorConfig any
-
-	// set config file name from cmd flag "config"
-	cfgFlag := flag.String("config", "config.json", "path to the configuration file")
-	flag.Parse()
-	configFileName := *cfgFlag
-
+func loadConfig(configFileName string) (*koanf.Koanf, error) {
 	kfg := koanf.New(".")
 	cfgFile := koanffile.Provider(configFileName)
 	kParser := koanfjson.Parser()
@@ -45,7 +38,14 @@ func getPollLaunchConfig() (*poll.LaunchConfig, error) {
 		return nil, fmt.Errorf("error loading configuration file: %w\n", err)
 	}
 
-	queueCtx, queueCancel := context.WithCancel(context.Background())
+	return kfg, nil
+}
+
+func getPollLaunchConfig(configFileName string) (*poll.LaunchConfig, error) {
+	kfg, err := loadConfig(configFileName)
+	if err != nil {
+		return nil, err
+	}
 
 	// reading poller parameters
 	pollConfig := poll.Config{}
@@ -53,56 +53,34 @@ func getPollLaunchConfig() (*poll.LaunchConfig, error) {
 		return nil, fmt.Errorf("error parsing 'poller' configuration: %w", err)
 	}
 
-	transConfig := transform.Config{}
-	if !kfg.Exists("transformer") {
-		transConfig.Type = ""
-	} else {
-		if err := kfg.Unmarshal("transformer", &transConfig); err != nil {
-			return nil, fmt.Errorf("error parsing 'transform' configuration: %w", err)
-		}
+	transConfig, err := transform.ParseConfig(kfg.Get("transformer"))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing 'transformer' configuration: %w", err)
 	}
 
-	// getting queues configuration
-	qType := kfg.String("queues.type")
+	queueConfig, err := getQueueConfigs(kfg)
+	if err != nil {
+		return nil, err
+	}
 
-	switch qType {
-	case "aws_sqs":
-		var qConfig []queue.AwsSQSConfig
-		if err := kfg.Unmarshal("queues.config", &qConfig); err != nil {
-			return nil, fmt.Errorf("error parsing queues configuration: %w\n", err)
-		}
-		copySliceElems(qConfig, &queueConfig)
-	case "gcp_pubsub":
-		var qConfig []queue.GcpPubSubConfig
-		if err := kfg.Unmarshal("queues.config", &qConfig); err != nil {
-			return nil, fmt.Errorf("error parsing queues configuration: %w\n", err)
-		}
-		copySliceElems(qConfig, &queueConfig)
-	default:
-		return nil, fmt.Errorf("unknown queue type %s", qType)
+	filterConfig := filter.Config{}
+	if err := kfg.Unmarshal("filter", &filterConfig); err != nil {
+		return nil, fmt.Errorf("error parsing 'filter' configuration: %w", err)
 	}
 
-	// getting process configuration
-	prType := kfg.String("processor.type")
+	msgFilter, err := filter.New(filterConfig)
+	if err != nil {
+		return nil, err
+	}
 
-	switch prType {
-	case "http":
-		prConfig := process.HttpConfig{}
-		if err := kfg.Unmarshal("processor.config", &prConfig); err != nil {
-			return nil, fmt.Errorf("error parsing process configuration: %w\n", err)
-		}
-		processorConfig = prConfig
-	default:
-		return nil, fmt.Errorf("unknown processor type %s\n", prType)
+	ingressConfig := ingress.Config{}
+	if err := kfg.Unmarshal("ingress", &ingressConfig); err != nil {
+		return nil, fmt.Errorf("error parsing 'ingress' configuration: %w", err)
 	}
 
-	queues := make([]queue.Queue, 0, 2)
-	for _, v := range queueConfig {
-		q, err := queue.New(queueCtx, v)
-		if err != nil {
-			return nil, fmt.Errorf("error adding queue: %w\n", err)
-		}
-		queues = append(queues, q)
+	processorConfig, err := getProcessorConfig(kfg, "processor")
+	if err != nil {
+		return nil, err
 	}
 
 	proc, err := process.New(processorConfig)
@@ -118,24 +96,188 @@ func getPollLaunchConfig() (*poll.LaunchConfig, error) {
 		}
 	}
 
-	var transFunc transform.TransformationFunc
+	var trans transform.Transformer
 	{
 		var err error
-		if transFunc, err = transform.New(transConfig.Type); err != nil {
+		if trans, err = transform.New(transConfig); err != nil {
 			return nil, err
 		}
 	}
 
+	queueRoutes, err := getQueueRoutes(kfg, poll.Route{Processor: proc, Transformer: trans, Filter: msgFilter})
+	if err != nil {
+		return nil, err
+	}
+
+	queueCtx, queueCancel := context.WithCancel(context.Background())
+	queues, err := getQueues(queueCtx, queueConfig)
+	if err != nil {
+		queueCancel()
+		return nil, err
+	}
+
+	routes := make(map[string]poll.Route)
+	for i, route := range queueRoutes {
+		if route != nil {
+			routes[queues[i].QueueId()] = *route
+		}
+	}
+
+	// callback listeners are started with processors and stopped along with queues
+	go func() {
+		<-queueCtx.Done()
+		process.ShutdownCallbackServers()
+	}()
+
+	if err := ingress.Start(queueCtx, ingressConfig, queues); err != nil {
+		queueCancel()
+		return nil, fmt.Errorf("error starting ingress: %w", err)
+	}
+
 	return &poll.LaunchConfig{
 		Queues:          queues,
 		Poller:          pollFunc,
 		Processor:       proc,
-		TransformFunc:   transFunc,
+		Transformer:     trans,
+		Filter:          msgFilter,
+		Routes:          routes,
 		QueueCancelFunc: queueCancel,
 		Concurrency:     pollConfig.Concurrency,
 	}, nil
 }
 
+func getProcessorConfig(kfg *koanf.Koanf, key string) (any, error) {
+	var processorConfig any
+
+	prType := kfg.String(key + ".type")
+
+	switch prType {
+	case "http":
+		prConfig := process.HttpConfig{}
+		if err := kfg.Unmarshal(key+".config", &prConfig); err != nil {
+			return nil, fmt.Errorf("error parsing process configuration: %w\n", err)
+		}
+		processorConfig = prConfig
+	case "grpc":
+		prConfig := process.GrpcConfig{}
+		if err := kfg.Unmarshal(key+".config", &prConfig); err != nil {
+			return nil, fmt.Errorf("error parsing process configuration: %w\n", err)
+		}
+		processorConfig = prConfig
+	case "exec":
+		prConfig := process.ExecConfig{}
+		if err := kfg.Unmarshal(key+".config", &prConfig); err != nil {
+			return nil, fmt.Errorf("error parsing process configuration: %w\n", err)
+		}
+		processorConfig = prConfig
+	case "worker":
+		prConfig := process.WorkerConfig{}
+		if err := kfg.Unmarshal(key+".config", &prConfig); err != nil {
+			return nil, fmt.Errorf("error parsing process configuration: %w\n", err)
+		}
+		processorConfig = prConfig
+	case "dapr":
+		prConfig := process.DaprConfig{}
+		if err := kfg.Unmarshal(key+".config", &prConfig); err != nil {
+			return nil, fmt.Errorf("error parsing process configuration: %w\n", err)
+		}
+		processorConfig = prConfig
+	default:
+		return nil, fmt.Errorf("unknown processor type %s\n", prType)
+	}
+
+	return processorConfig, nil
+}
+
+func getQueueConfigs(kfg *koanf.Koanf) ([]any, error) {
+	var queueConfig []any
+	qType := kfg.String("queues.type")
+
+	switch qType {
+	case "aws_sqs":
+		var qConfig []queue.AwsSQSConfig
+		if err := kfg.Unmarshal("queues.config", &qConfig); err != nil {
+			return nil, fmt.Errorf("error parsing queues configuration: %w\n", err)
+		}
+		copySliceElems(qConfig, &queueConfig)
+	case "gcp_pubsub":
+		var qConfig []queue.GcpPubSubConfig
+		if err := kfg.Unmarshal("queues.config", &qConfig); err != nil {
+			return nil, fmt.Errorf("error parsing queues configuration: %w\n", err)
+		}
+		copySliceElems(qConfig, &queueConfig)
+	default:
+		return nil, fmt.Errorf("unknown queue type %s", qType)
+	}
+
+	return queueConfig, nil
+}
+
+// getQueueRoutes reads per queue processor, transformer and filter overrides, the result has
+// an entry for every queue definition which is nil when nothing is overridden
+func getQueueRoutes(kfg *koanf.Koanf, defaultRoute poll.Route) ([]*poll.Route, error) {
+	queueKfgs := kfg.Slices("queues.config")
+	routes := make([]*poll.Route, len(queueKfgs))
+
+	for i, qk := range queueKfgs {
+		if !qk.Exists("processor") && !qk.Exists("transformer") && !qk.Exists("filter") {
+			continue
+		}
+
+		route := defaultRoute
+		if qk.Exists("processor") {
+			processorConfig, err := getProcessorConfig(qk, "processor")
+			if err != nil {
+				return nil, fmt.Errorf("queue #%d: %w", i+1, err)
+			}
+
+			if route.Processor, err = process.New(processorConfig); err != nil {
+				return nil, fmt.Errorf("queue #%d: error adding processor: %w", i+1, err)
+			}
+		}
+
+		if qk.Exists("transformer") {
+			transConfig, err := transform.ParseConfig(qk.Get("transformer"))
+			if err != nil {
+				return nil, fmt.Errorf("queue #%d: error parsing 'transformer' configuration: %w", i+1, err)
+			}
+
+			if route.Transformer, err = transform.New(transConfig); err != nil {
+				return nil, fmt.Errorf("queue #%d: %w", i+1, err)
+			}
+		}
+
+		if qk.Exists("filter") {
+			filterConfig := filter.Config{}
+			if err := qk.Unmarshal("filter", &filterConfig); err != nil {
+				return nil, fmt.Errorf("queue #%d: error parsing 'filter' configuration: %w", i+1, err)
+			}
+
+			var err error
+			if route.Filter, err = filter.New(filterConfig); err != nil {
+				return nil, fmt.Errorf("queue #%d: %w", i+1, err)
+			}
+		}
+
+		routes[i] = &route
+	}
+
+	return routes, nil
+}
+
+func getQueues(ctx context.Context, queueConfig []any) ([]queue.Queue, error) {
+	queues := make([]queue.Queue, 0, len(queueConfig))
+	for _, v := range queueConfig {
+		q, err := queue.New(ctx, v)
+		if err != nil {
+			return nil, fmt.Errorf("error adding queue: %w\n", err)
+		}
+		queues = append(queues, q)
+	}
+
+	return queues, nil
+}
+
 func copySliceElems[T any](source []T, target *[]any) {
 	for _, v := range source {
 		*target = append(*target, v)
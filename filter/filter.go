@@ -0,0 +1,150 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package filter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/process"
+	"github.com/Burmuley/priority-pubsub/transform"
+	"time"
+)
+
+const DefaultErrorDelay = 300
+
+var (
+	ErrConfig = errors.New("filter configuration error")
+)
+
+// Config is the filter messages are checked with after transformation, before processing
+type Config struct {
+	// Expression is the jq expression evaluated over the message data,
+	// the message matches when the first result is neither false nor null
+	Expression string `koanf:"expression"`
+	// OnMismatch is one of "ack" (default), "retry" or "dead_letter"
+	OnMismatch process.Outcome `koanf:"on_mismatch"`
+	// Delay in seconds the message stays invisible for, required with "retry",
+	// so mismatching messages are not received again right away
+	Delay int `koanf:"delay"`
+	// ErrorDelay in seconds the message the expression fails for stays invisible for
+	// when the queue has no dead letter destination
+	ErrorDelay int `koanf:"error_delay"`
+}
+
+type Filter struct {
+	config Config
+	query  *transform.JqQuery
+}
+
+// New returns the filter, nil is returned when no expression is configured
+func New(config Config) (*Filter, error) {
+	if config.Expression == "" {
+		return nil, nil
+	}
+
+	if config.OnMismatch == "" {
+		config.OnMismatch = process.OutcomeAck
+	}
+
+	if config.ErrorDelay == 0 {
+		config.ErrorDelay = DefaultErrorDelay
+	}
+
+	switch config.OnMismatch {
+	case process.OutcomeAck, process.OutcomeRetry, process.OutcomeDeadLetter:
+	default:
+		return nil, fmt.Errorf("%w: unsupported 'on_mismatch' value %q", ErrConfig, config.OnMismatch)
+	}
+
+	if config.OnMismatch == process.OutcomeRetry && config.Delay <= 0 {
+		return nil, fmt.Errorf("%w: 'on_mismatch' value %q requires positive 'delay'", ErrConfig, config.OnMismatch)
+	}
+
+	if config.ErrorDelay < 0 {
+		return nil, fmt.Errorf("%w: 'error_delay' should be positive", ErrConfig)
+	}
+
+	query, err := transform.NewJqQuery(config.Expression)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+
+	return &Filter{config: config, query: query}, nil
+}
+
+// Wrap returns the transformer running the filter over the result of t, t may be nil;
+// the message not matching the filter or failing to evaluate is not passed to the processor
+func (f *Filter) Wrap(t transform.Transformer) transform.Transformer {
+	if f == nil {
+		return t
+	}
+
+	return transform.EnvelopeFunc(func(e transform.Envelope) (transform.Envelope, error) {
+		var err error
+		if t != nil {
+			if e, err = t.Transform(e); err != nil {
+				return e, err
+			}
+		}
+
+		match, err := f.Match(e)
+		if err != nil {
+			// the error is caused by the message, so it's not received again right away
+			// when it can not be dead-lettered
+			return e, &process.DelayError{
+				Err:   fmt.Errorf("%w: filter error: %w", process.ErrDeadLetter, err),
+				Delay: time.Duration(f.config.ErrorDelay) * time.Second,
+			}
+		}
+
+		if !match {
+			return e, f.mismatchErr()
+		}
+
+		return e, nil
+	})
+}
+
+// Match evaluates the expression over the envelope, data which is not valid JSON is passed as null,
+// so such messages could still be matched by attributes
+func (f *Filter) Match(e transform.Envelope) (bool, error) {
+	input, err := transform.DecodeJson(e.Data)
+	if err != nil {
+		input = nil
+	}
+
+	result, ok, err := f.query.Run(input, e)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return result != nil && result != false, nil
+}
+
+// mismatchErr returns the processing result for messages not matching the filter
+func (f *Filter) mismatchErr() error {
+	const reason = "message does not match the filter"
+
+	switch f.config.OnMismatch {
+	case process.OutcomeRetry:
+		return process.OutcomeRetryDelay.Err(time.Duration(f.config.Delay)*time.Second, reason)
+	case process.OutcomeDeadLetter:
+		return process.OutcomeDeadLetter.Err(0, reason)
+	}
+
+	return fmt.Errorf("%w: %s", process.ErrSkip, reason)
+}
@@ -0,0 +1,310 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ingress
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultListenAddress = "127.0.0.1:8080"
+	DefaultMaxBodySize   = 256 * 1024
+	PriorityHeader       = "X-Priority"
+	PriorityParam        = "priority"
+	// AttributeHeaderPrefix marks request headers which are passed as message attributes
+	AttributeHeaderPrefix = "X-Attribute-"
+
+	enqueuePath       = "/enqueue"
+	shutdownTimeout   = 10 * time.Second
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+)
+
+var (
+	ErrConfig = errors.New("ingress configuration error")
+)
+
+var (
+	logInfo = log.New(os.Stdout, "[PRIORITY_PUBSUB] [INFO] ", log.LstdFlags|log.Lmsgprefix)
+	logErr  = log.New(os.Stderr, "[PRIORITY_PUBSUB] [ERROR] ", log.LstdFlags|log.Lmsgprefix)
+)
+
+// Config enables the HTTP endpoint producers publish messages through
+type Config struct {
+	Enabled       bool   `koanf:"enabled"`
+	ListenAddress string `koanf:"listen_address"`
+	// DefaultPriority is used when the request does not specify one
+	DefaultPriority string     `koanf:"default_priority"`
+	Priorities      []Priority `koanf:"priorities"`
+	MaxBodySize     int64      `koanf:"max_body_size"`
+	Auth            AuthConfig `koanf:"auth"`
+}
+
+// AuthConfig requires producers to authenticate, the token is read once on start
+type AuthConfig struct {
+	// Type is "bearer", requests are not authenticated when empty
+	Type      string `koanf:"type"`
+	TokenFile string `koanf:"token_file"`
+	TokenEnv  string `koanf:"token_env"`
+}
+
+// Priority maps a priority label to the ID of the queue
+type Priority struct {
+	Label string `koanf:"label"`
+	Queue string `koanf:"queue"`
+}
+
+type ingress struct {
+	config     Config
+	publishers map[string]queue.Publisher
+	// token is the expected bearer token, empty when authentication is disabled
+	token string
+}
+
+// Start starts the ingress server which is shut down once ctx is done
+func Start(ctx context.Context, config Config, queues []queue.Queue) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	if config.ListenAddress == "" {
+		config.ListenAddress = DefaultListenAddress
+	}
+
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = DefaultMaxBodySize
+	}
+
+	if len(config.Priorities) == 0 {
+		return fmt.Errorf("%w: 'priorities' should not be empty", ErrConfig)
+	}
+
+	queueMap := make(map[string]queue.Queue, len(queues))
+	for _, q := range queues {
+		queueMap[q.QueueId()] = q
+	}
+
+	in := &ingress{
+		config:     config,
+		publishers: make(map[string]queue.Publisher, len(config.Priorities)),
+	}
+
+	for _, p := range config.Priorities {
+		if p.Label == "" {
+			return fmt.Errorf("%w: priority label should not be empty", ErrConfig)
+		}
+
+		if _, ok := in.publishers[p.Label]; ok {
+			return fmt.Errorf("%w: duplicate priority label %q", ErrConfig, p.Label)
+		}
+
+		q, ok := queueMap[p.Queue]
+		if !ok {
+			return fmt.Errorf("%w: queue %q of priority %q is not configured", ErrConfig, p.Queue, p.Label)
+		}
+
+		publisher, ok := q.(queue.Publisher)
+		if !ok {
+			return fmt.Errorf("%w: queue %q does not support publishing", ErrConfig, p.Queue)
+		}
+
+		in.publishers[p.Label] = publisher
+	}
+
+	if _, ok := in.publishers[config.DefaultPriority]; config.DefaultPriority != "" && !ok {
+		return fmt.Errorf("%w: unknown default priority %q", ErrConfig, config.DefaultPriority)
+	}
+
+	var err error
+	if in.token, err = authToken(config.Auth); err != nil {
+		return err
+	}
+
+	if in.token == "" && !isLoopback(config.ListenAddress) {
+		logErr.Printf("ingress on %s accepts unauthenticated requests, consider configuring 'auth'\n", config.ListenAddress)
+	}
+
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("%w: error starting listener: %w", ErrConfig, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(enqueuePath, in.handleEnqueue)
+	// slow clients should not hold connections forever
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	go func() {
+		logInfo.Printf("ingress listening on %s\n", listener.Addr())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErr.Printf("ingress listener error: %s\n", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logErr.Printf("error shutting down ingress: %s\n", err)
+		}
+	}()
+
+	return nil
+}
+
+func (in *ingress) handleEnqueue(w http.ResponseWriter, r *http.Request) {
+	if !in.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	priority := r.URL.Query().Get(PriorityParam)
+	if priority == "" {
+		priority = r.Header.Get(PriorityHeader)
+	}
+	if priority == "" {
+		priority = in.config.DefaultPriority
+	}
+
+	publisher, ok := in.publishers[priority]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown priority %q", priority), http.StatusBadRequest)
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, in.config.MaxBodySize))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "request body is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "error reading request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := publisher.Publish(r.Context(), data, attributes(r.Header)); err != nil {
+		logErr.Printf("error publishing message with priority %q: %s\n", priority, err)
+		http.Error(w, "error publishing message", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// attributes collects message attributes from X-Attribute-<Name> headers,
+// attribute names are lower-cased
+func attributes(header http.Header) map[string]string {
+	result := make(map[string]string)
+	for name, values := range header {
+		if !strings.HasPrefix(name, AttributeHeaderPrefix) || len(values) == 0 {
+			continue
+		}
+
+		attrName := strings.ToLower(strings.TrimPrefix(name, AttributeHeaderPrefix))
+		if attrName != "" {
+			result[attrName] = values[0]
+		}
+	}
+
+	return result
+}
+
+// authorized reports whether the request carries the expected bearer token
+func (in *ingress) authorized(r *http.Request) bool {
+	if in.token == "" {
+		return true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(in.token)) == 1
+}
+
+// authToken reads the bearer token producers should present, empty token is returned
+// when authentication is disabled
+func authToken(config AuthConfig) (string, error) {
+	switch config.Type {
+	case "":
+		return "", nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("%w: authentication type %q is not supported", ErrConfig, config.Type)
+	}
+
+	if (config.TokenFile == "") == (config.TokenEnv == "") {
+		return "", fmt.Errorf("%w: exactly one of 'token_file' or 'token_env' should be set", ErrConfig)
+	}
+
+	var token string
+	if config.TokenEnv != "" {
+		token = os.Getenv(config.TokenEnv)
+	} else {
+		data, err := os.ReadFile(config.TokenFile)
+		if err != nil {
+			return "", fmt.Errorf("%w: error reading token file: %w", ErrConfig, err)
+		}
+		token = string(data)
+	}
+
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("%w: bearer token should not be empty", ErrConfig)
+	}
+
+	return token, nil
+}
+
+// isLoopback reports whether the listen address accepts local connections only
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	if host == "localhost" {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
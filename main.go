@@ -17,6 +17,7 @@
 package main
 
 import (
+	"flag"
 	"github.com/Burmuley/priority-pubsub/poll"
 	"log"
 	"os"
@@ -28,9 +29,20 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "publish" {
+		if err := runPublish(os.Args[2:]); err != nil {
+			logErr.Fatal(err)
+		}
+		return
+	}
+
+	// set config file name from cmd flag "config"
+	cfgFlag := flag.String("config", "config.json", "path to the configuration file")
+	flag.Parse()
+
 	logInfo.Println("Priority Pub/Sub started")
 
-	launchConfig, err := getPollLaunchConfig()
+	launchConfig, err := getPollLaunchConfig(*cfgFlag)
 	if err != nil {
 		logErr.Fatal(err)
 	}
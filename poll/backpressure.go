@@ -0,0 +1,61 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package poll
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// backpressure is shared between all pollers and used to pause polling
+// when the subscriber signals it is overloaded
+var backpressure = &pause{}
+
+type pause struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// Extend pauses polling for the given duration unless it's already paused for longer
+func (p *pause) Extend(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until := time.Now().Add(d); until.After(p.until) {
+		p.until = until
+	}
+}
+
+// Wait blocks until the pause is over or the context is cancelled
+func (p *pause) Wait(ctx context.Context) {
+	for {
+		p.mu.Lock()
+		delay := time.Until(p.until)
+		p.mu.Unlock()
+
+		if delay <= 0 {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
@@ -19,6 +19,7 @@ package poll
 import (
 	"context"
 	"fmt"
+	"github.com/Burmuley/priority-pubsub/filter"
 	"github.com/Burmuley/priority-pubsub/process"
 	"github.com/Burmuley/priority-pubsub/queue"
 	"github.com/Burmuley/priority-pubsub/transform"
@@ -44,11 +45,21 @@ type LaunchConfig struct {
 	Poller          Poller
 	Processor       process.Processor
 	QueueCancelFunc context.CancelFunc
-	TransformFunc   transform.TransformationFunc
-	Concurrency     int
+	Transformer     transform.Transformer
+	Filter          *filter.Filter
+	// Routes overrides Processor, Transformer and Filter for particular queue IDs
+	Routes      map[string]Route
+	Concurrency int
 }
 
-type Poller func(ctx context.Context, wg *sync.WaitGroup, queues []queue.Queue, proc process.Processor, trans transform.TransformationFunc)
+// Route is the processor, the transformer and the filter messages of a queue are handled with
+type Route struct {
+	Processor   process.Processor
+	Transformer transform.Transformer
+	Filter      *filter.Filter
+}
+
+type Poller func(ctx context.Context, wg *sync.WaitGroup, queues []queue.Queue, routes map[string]Route)
 
 func New(poller string) (Poller, error) {
 	switch poller {
@@ -63,8 +74,19 @@ func Run(cfg LaunchConfig) {
 	wg := &sync.WaitGroup{}
 	prCtx, prCancel := context.WithCancel(context.Background())
 
+	routes := make(map[string]Route, len(cfg.Queues))
+	for _, q := range cfg.Queues {
+		route, ok := cfg.Routes[q.QueueId()]
+		if !ok {
+			route = Route{Processor: cfg.Processor, Transformer: cfg.Transformer, Filter: cfg.Filter}
+		}
+		// the filter runs as the last transformation step, so it sees the transformed envelope
+		route.Transformer = route.Filter.Wrap(route.Transformer)
+		routes[q.QueueId()] = route
+	}
+
 	for i := 0; i < cfg.Concurrency; i++ {
-		go cfg.Poller(prCtx, wg, cfg.Queues, cfg.Processor, cfg.TransformFunc)
+		go cfg.Poller(prCtx, wg, cfg.Queues, routes)
 		wg.Add(1)
 	}
 
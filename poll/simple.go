@@ -21,12 +21,11 @@ import (
 	"errors"
 	"github.com/Burmuley/priority-pubsub/process"
 	"github.com/Burmuley/priority-pubsub/queue"
-	"github.com/Burmuley/priority-pubsub/transform"
 	"sync"
 	"time"
 )
 
-func SimplePoller(ctx context.Context, wg *sync.WaitGroup, queues []queue.Queue, proc process.Processor, trans transform.TransformationFunc) {
+func SimplePoller(ctx context.Context, wg *sync.WaitGroup, queues []queue.Queue, routes map[string]Route) {
 	var message queue.Message
 	var procErr, err error
 
@@ -41,6 +40,11 @@ func SimplePoller(ctx context.Context, wg *sync.WaitGroup, queues []queue.Queue,
 			wg.Done()
 			return
 		default:
+			backpressure.Wait(ctx)
+			if ctx.Err() != nil {
+				continue
+			}
+
 			message = nil
 			message, err = receiveMessage(queues)
 			if err != nil {
@@ -57,45 +61,86 @@ func SimplePoller(ctx context.Context, wg *sync.WaitGroup, queues []queue.Queue,
 			logInfo.Printf("got message %q from %q\n", message.Id(), message.QueueId())
 			logInfo.Printf("processing message %q from %q\n", message.Id(), message.QueueId())
 
-			messageQueue := queueNames[message.QueueId()]
-			procErr = proc.Run(ctx, message, trans)
+			route := routes[message.QueueId()]
+			procErr = route.Processor.Run(ctx, message, route.Transformer)
+			handleResult(queueNames[message.QueueId()], message, procErr)
+			message = nil
+		}
+	}
+}
 
-			if procErr != nil {
-				if errors.Is(procErr, process.ErrFatal) {
-					logErr.Printf("fatal error occurred during task processing: %s\n", procErr.Error())
-					if err := messageQueue.DeleteMessage(message); err != nil {
-						logErr.Printf("error deleting message %q to the queue %q: %s\n", message.Id(), message.QueueId(), err)
-						message = nil
-						continue
-					}
+// handleResult deletes, returns or dead-letters the message depending on the processing result
+func handleResult(q queue.Queue, message queue.Message, procErr error) {
+	var delayErr *process.DelayError
+
+	switch {
+	case procErr == nil:
+		logInfo.Printf("message %q from %q has been processed successfully\n", message.Id(), message.QueueId())
+		deleteMessage(q, message)
+	case errors.Is(procErr, process.ErrSkip):
+		logInfo.Printf("message %q from %q has been skipped: %s\n", message.Id(), message.QueueId(), procErr.Error())
+		deleteMessage(q, message)
+	case errors.Is(procErr, process.ErrDeadLetter):
+		logErr.Printf("message %q can not be processed and should be dead-lettered: %s\n", message.Id(), procErr.Error())
+		// the delay, if any, applies when the message is returned to the queue instead
+		var delay time.Duration
+		if errors.As(procErr, &delayErr) {
+			delay = delayErr.Delay
+		}
+		deadLetterMessage(q, message, delay)
+	case errors.Is(procErr, process.ErrFatal):
+		logErr.Printf("fatal error occurred during task processing: %s\n", procErr.Error())
+		deleteMessage(q, message)
+	case errors.As(procErr, &delayErr):
+		logErr.Printf("message %q processing error: %s\n", message.Id(), procErr.Error())
+		if errors.Is(procErr, process.ErrThrottle) {
+			logInfo.Printf("pausing polling for %s\n", delayErr.Delay)
+			backpressure.Extend(delayErr.Delay)
+		}
+		returnMessage(q, message, delayErr.Delay)
+	default:
+		logErr.Printf("message %q processing error: %s\n", message.Id(), procErr.Error())
+		returnMessage(q, message, 0)
+	}
+}
 
-					logInfo.Printf("successfully deleted message %q from the queue %q\n", message.Id(), message.QueueId())
-					message = nil
-					continue
-				}
+func deleteMessage(q queue.Queue, message queue.Message) {
+	if err := q.DeleteMessage(message); err != nil {
+		logErr.Printf("error deleting message %q from the queue %q: %s\n", message.Id(), message.QueueId(), err)
+		return
+	}
 
-				logErr.Printf("message %q processing error: %s\n", message.Id(), procErr.Error())
-				if err := messageQueue.ReturnMessage(message); err != nil {
-					logErr.Printf("error returning message %q to the queue %q: %s\n", message.Id(), message.QueueId(), err)
-					message = nil
-					continue
-				}
+	logInfo.Printf("successfully deleted message %q from the queue %q\n", message.Id(), message.QueueId())
+}
 
-				logInfo.Printf("successfully returned message %q to the queue %q\n", message.Id(), message.QueueId())
-				message = nil
-				continue
-			}
+func returnMessage(q queue.Queue, message queue.Message, delay time.Duration) {
+	if err := q.ReturnMessageWithDelay(message, delay); err != nil {
+		logErr.Printf("error returning message %q to the queue %q: %s\n", message.Id(), message.QueueId(), err)
+		return
+	}
 
-			logInfo.Printf("message %q from %q has been processed successfully\n", message.Id(), message.QueueId())
-			if err := messageQueue.DeleteMessage(message); err != nil {
-				logErr.Printf("error deleting message %q from the queue %q: %s\n", message.Id(), message.QueueId(), err)
-				message = nil
-			}
+	if delay > 0 {
+		logInfo.Printf("successfully returned message %q to the queue %q with delay %s\n", message.Id(), message.QueueId(), delay)
+		return
+	}
 
-			logInfo.Printf("successfully deleted message %q from the queue %q\n", message.Id(), message.QueueId())
-			message = nil
+	logInfo.Printf("successfully returned message %q to the queue %q\n", message.Id(), message.QueueId())
+}
+
+func deadLetterMessage(q queue.Queue, message queue.Message, delay time.Duration) {
+	if err := q.DeadLetterMessage(message); err != nil {
+		// let the queue native redrive policy handle the message
+		if errors.Is(err, queue.ErrNoDeadLetter) {
+			logInfo.Printf("no dead letter destination configured for the queue %q\n", message.QueueId())
+			returnMessage(q, message, delay)
+			return
 		}
+
+		logErr.Printf("error dead-lettering message %q from the queue %q: %s\n", message.Id(), message.QueueId(), err)
+		return
 	}
+
+	logInfo.Printf("successfully moved message %q from the queue %q to the dead letter destination\n", message.Id(), message.QueueId())
 }
 
 func receiveMessage(queues []queue.Queue) (queue.Message, error) {
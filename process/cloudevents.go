@@ -0,0 +1,171 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/transform"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	CloudEventsSpecVersion    = "1.0"
+	CloudEventsContentType    = "application/cloudevents+json"
+	CloudEventsDefaultType    = "priority-pubsub.message"
+	CloudEventsModeBinary     = "binary"
+	CloudEventsModeStructured = "structured"
+
+	// message attributes with this prefix are used as CloudEvents context attributes
+	cloudEventsAttrPrefix = "ce-"
+)
+
+// CloudEventsConfig enables delivery of messages as CloudEvents
+type CloudEventsConfig struct {
+	// Mode is either "binary" or "structured", CloudEvents are not used when empty
+	Mode string `koanf:"mode"`
+	// Type is the event type used when the message has no type attribute
+	Type string `koanf:"type"`
+	// TypeAttribute and SourceAttribute are names of message attributes to take event type and source from
+	TypeAttribute   string `koanf:"type_attribute"`
+	SourceAttribute string `koanf:"source_attribute"`
+}
+
+type cloudEventsEncoder struct {
+	config CloudEventsConfig
+}
+
+func newCloudEventsEncoder(config CloudEventsConfig) (*cloudEventsEncoder, error) {
+	switch config.Mode {
+	case "":
+		return nil, nil
+	case CloudEventsModeBinary, CloudEventsModeStructured:
+	default:
+		return nil, fmt.Errorf("%w: unknown CloudEvents mode %q", ErrConfig, config.Mode)
+	}
+
+	if config.Type == "" {
+		config.Type = CloudEventsDefaultType
+	}
+
+	return &cloudEventsEncoder{config: config}, nil
+}
+
+// contextAttributes builds event context attributes from the message envelope: "ce-" prefixed message attributes
+// are used as is, id defaults to the message ID and source to the queue ID
+func (c *cloudEventsEncoder) contextAttributes(env transform.Envelope) map[string]string {
+	attributes := map[string]string{
+		"id":     env.MessageId,
+		"source": env.QueueId,
+		"type":   c.config.Type,
+	}
+
+	for name, value := range env.Attributes {
+		if ceName, ok := strings.CutPrefix(strings.ToLower(name), cloudEventsAttrPrefix); ok && ceName != "" {
+			attributes[ceName] = value
+		}
+	}
+
+	if value, ok := env.Attributes[c.config.TypeAttribute]; ok && c.config.TypeAttribute != "" {
+		attributes["type"] = value
+	}
+
+	if value, ok := env.Attributes[c.config.SourceAttribute]; ok && c.config.SourceAttribute != "" {
+		attributes["source"] = value
+	}
+
+	// these are defined by the delivery itself
+	delete(attributes, "specversion")
+	delete(attributes, "datacontenttype")
+	return attributes
+}
+
+// encode returns request headers and body carrying the message envelope as CloudEvent
+func (c *cloudEventsEncoder) encode(env transform.Envelope) (http.Header, []byte, error) {
+	attributes := c.contextAttributes(env)
+	header := http.Header{}
+
+	if c.config.Mode == CloudEventsModeBinary {
+		header.Set("Content-Type", env.ContentType)
+		header.Set("ce-specversion", CloudEventsSpecVersion)
+		for name, value := range attributes {
+			header.Set(cloudEventsAttrPrefix+name, value)
+		}
+		return header, env.Data, nil
+	}
+
+	event := make(map[string]any, len(attributes))
+	for name, value := range attributes {
+		event[name] = value
+	}
+
+	body, err := structuredCloudEvent(event, env.ContentType, env.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header.Set("Content-Type", CloudEventsContentType)
+	return header, body, nil
+}
+
+// isCloudEvent reports whether data is already a CloudEvent in the structured mode
+func isCloudEvent(data []byte) bool {
+	var event struct {
+		SpecVersion string `json:"specversion"`
+	}
+
+	if err := json.Unmarshal(data, &event); err != nil {
+		return false
+	}
+
+	return event.SpecVersion != ""
+}
+
+// structuredCloudEvent builds the CloudEvent in the structured mode from the context attributes and data,
+// JSON data is embedded as is, other text data as string and binary data is base64 encoded
+func structuredCloudEvent(attributes map[string]any, contentType string, data []byte) ([]byte, error) {
+	event := make(map[string]any, len(attributes)+3)
+	for name, value := range attributes {
+		event[name] = value
+	}
+
+	event["specversion"] = CloudEventsSpecVersion
+	event["datacontenttype"] = contentType
+
+	switch {
+	case isJsonContentType(contentType) && json.Valid(data):
+		event["data"] = json.RawMessage(data)
+	case utf8.Valid(data):
+		event["data"] = string(data)
+	default:
+		event["data_base64"] = data
+	}
+
+	return json.Marshal(event)
+}
+
+func isJsonContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
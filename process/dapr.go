@@ -0,0 +1,270 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"github.com/Burmuley/priority-pubsub/transform"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DaprDefaultPubsubName = "priority-pubsub"
+	DaprEventType         = "com.dapr.event.sent"
+	DaprSubscribePath     = "/dapr/subscribe"
+)
+
+// DaprConfig configures delivery of messages the same way Dapr sidecar does,
+// SubscriberUrl is the base URL of the application when DiscoverRoutes is set
+type DaprConfig struct {
+	HttpConfig `koanf:",squash"`
+	PubsubName string `koanf:"pubsub_name"`
+	// Topics maps queue IDs to topic names, queue ID is used as topic name by default
+	Topics         []DaprTopic `koanf:"topics"`
+	DiscoverRoutes bool        `koanf:"discover_routes"`
+}
+
+type DaprTopic struct {
+	Queue string `koanf:"queue"`
+	Topic string `koanf:"topic"`
+}
+
+// Dapr delivers messages as CloudEvents and interprets responses following
+// the Dapr pub/sub application contract
+type Dapr struct {
+	config DaprConfig
+	http   *Http
+	topics map[string]string
+
+	mu     sync.Mutex
+	routes map[string]string
+}
+
+// daprSubscription is an element of the GET /dapr/subscribe response
+type daprSubscription struct {
+	PubsubName string `json:"pubsubname"`
+	Topic      string `json:"topic"`
+	Route      string `json:"route"`
+	Routes     struct {
+		Default string `json:"default"`
+	} `json:"routes"`
+}
+
+func NewDapr(config DaprConfig) (*Dapr, error) {
+	if config.PubsubName == "" {
+		config.PubsubName = DaprDefaultPubsubName
+	}
+
+	if config.Async.Enabled || config.CloudEvents.Mode != "" {
+		return nil, fmt.Errorf("%w: options 'async' and 'cloudevents' are not supported by the Dapr contract", ErrConfig)
+	}
+
+	if config.BodyTemplate != "" || strings.Contains(config.SubscriberUrl, "{{") {
+		return nil, fmt.Errorf("%w: templates are not supported by the Dapr processor", ErrConfig)
+	}
+
+	if config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+
+	config.Method = http.MethodPost
+	h, err := NewHttp(config.HttpConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	topics := make(map[string]string, len(config.Topics))
+	for _, t := range config.Topics {
+		if t.Queue == "" || t.Topic == "" {
+			return nil, fmt.Errorf("%w: fields 'queue' and 'topic' are mandatory for topics", ErrConfig)
+		}
+		topics[t.Queue] = t.Topic
+	}
+
+	return &Dapr{config: config, http: h, topics: topics}, nil
+}
+
+func (d *Dapr) Run(ctx context.Context, msg queue.Message, trans transform.Transformer) error {
+	env, err := transformMessage(msg, trans)
+	if err != nil {
+		return err
+	}
+
+	data := env.Data
+	contentType := env.ContentType
+	if contentType == "" {
+		contentType = d.http.config.ContentType
+	}
+
+	topic, ok := d.topics[msg.QueueId()]
+	if !ok {
+		topic = msg.QueueId()
+	}
+
+	// messages published by Dapr are CloudEvents already
+	if !isCloudEvent(data) {
+		var err error
+		data, err = structuredCloudEvent(map[string]any{
+			"id":         msg.Id(),
+			"source":     d.config.PubsubName,
+			"type":       DaprEventType,
+			"topic":      topic,
+			"pubsubname": d.config.PubsubName,
+		}, contentType, data)
+
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrFatal, err)
+		}
+	}
+
+	target, err := d.routeUrl(ctx, topic)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFail, err)
+	}
+
+	resChan := make(chan error, 1)
+	go func() {
+		resChan <- d.send(ctx, target, data)
+	}()
+
+	select {
+	case res := <-resChan:
+		return res
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dapr) send(ctx context.Context, target string, data []byte) error {
+	resp, err := d.http.do(ctx, http.MethodPost, target, http.Header{"Content-Type": {CloudEventsContentType}}, data)
+	if err != nil {
+		return err
+	}
+	defer drainBody(resp)
+
+	if _, ok := lookupOutcome(d.http.config.StatusOutcomes, resp.StatusCode); ok {
+		return d.http.responseError(resp)
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: response status code %d", ErrFatal, resp.StatusCode)
+	}
+
+	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return d.http.responseError(resp)
+	}
+
+	// empty or non-JSON body means success
+	var result struct {
+		Status string `json:"status"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	_ = json.Unmarshal(body, &result)
+
+	switch strings.ToUpper(result.Status) {
+	case "", "SUCCESS":
+		return nil
+	case "RETRY":
+		return fmt.Errorf("%w: application requested retry", ErrFail)
+	case "DROP":
+		return fmt.Errorf("%w: application requested drop", ErrFatal)
+	}
+
+	return fmt.Errorf("%w: unknown status %q in the response", ErrFail, result.Status)
+}
+
+// routeUrl returns the URL to deliver messages of the topic to, the application
+// routes are discovered on the first call
+func (d *Dapr) routeUrl(ctx context.Context, topic string) (string, error) {
+	if !d.config.DiscoverRoutes {
+		return d.http.config.SubscriberUrl, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.routes == nil {
+		routes, err := d.discoverRoutes(ctx)
+		if err != nil {
+			return "", err
+		}
+		d.routes = routes
+	}
+
+	route, ok := d.routes[topic]
+	if !ok {
+		logErr.Printf("application has no subscription for topic %q, using %q\n", topic, d.http.config.SubscriberUrl)
+		return d.http.config.SubscriberUrl, nil
+	}
+
+	return route, nil
+}
+
+func (d *Dapr) discoverRoutes(ctx context.Context) (map[string]string, error) {
+	base, err := url.Parse(d.http.config.SubscriberUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := d.http.do(reqCtx, http.MethodGet, base.JoinPath(DaprSubscribePath).String(), nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error discovering routes: %w", err)
+	}
+	defer drainBody(resp)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error discovering routes: response status code %d", resp.StatusCode)
+	}
+
+	var subscriptions []daprSubscription
+	if err := json.NewDecoder(resp.Body).Decode(&subscriptions); err != nil {
+		return nil, fmt.Errorf("error discovering routes: %w", err)
+	}
+
+	routes := make(map[string]string)
+	for _, sub := range subscriptions {
+		if sub.PubsubName != d.config.PubsubName {
+			continue
+		}
+
+		// routing rules are not supported, only the default route is used
+		route := sub.Route
+		if sub.Routes.Default != "" {
+			route = sub.Routes.Default
+		}
+
+		if route == "" {
+			continue
+		}
+
+		routes[sub.Topic] = base.JoinPath(route).String()
+		logInfo.Printf("discovered route %q for topic %q\n", routes[sub.Topic], sub.Topic)
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"github.com/Burmuley/priority-pubsub/transform"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	ExecDefaultTimeout = 120
+	ExecEnvPrefix      = "PRIORITY_PUBSUB_"
+)
+
+type ExecConfig struct {
+	// Command is the executable and its arguments
+	Command []string `koanf:"command"`
+	WorkDir string   `koanf:"work_dir"`
+	// Env is the list of additional "KEY=value" environment variables
+	Env            []string        `koanf:"env"`
+	Timeout        int             `koanf:"timeout"`
+	ExitOutcomes   []StatusOutcome `koanf:"exit_outcomes"`
+	DefaultOutcome Outcome         `koanf:"default_outcome"`
+}
+
+// Exec runs the command for each message, passes the message data to its
+// stdin and the message metadata as environment variables
+type Exec struct {
+	config ExecConfig
+}
+
+func NewExec(config ExecConfig) (*Exec, error) {
+	if len(config.Command) == 0 || config.Command[0] == "" {
+		return nil, fmt.Errorf("%w: field 'command' is mandatory", ErrConfig)
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = ExecDefaultTimeout
+	}
+
+	if config.Timeout < 0 {
+		return nil, fmt.Errorf("%w: 'timeout' should be positive", ErrConfig)
+	}
+
+	if config.DefaultOutcome == "" {
+		config.DefaultOutcome = OutcomeRetry
+	}
+
+	if err := config.DefaultOutcome.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.DefaultOutcome == OutcomeRetryDelay {
+		return nil, fmt.Errorf("%w: 'default_outcome' can not be %q, use 'exit_outcomes' instead", ErrConfig, config.DefaultOutcome)
+	}
+
+	for i := range config.ExitOutcomes {
+		if err := config.ExitOutcomes[i].parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Exec{config: config}, nil
+}
+
+func (e *Exec) Run(ctx context.Context, msg queue.Message, trans transform.Transformer) error {
+	env, err := transformMessage(msg, trans)
+	if err != nil {
+		return err
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, time.Duration(e.config.Timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(cmdCtx, e.config.Command[0], e.config.Command[1:]...)
+	cmd.Dir = e.config.WorkDir
+	cmd.Env = append(append(os.Environ(), e.config.Env...), messageEnv(env)...)
+	cmd.Stdin = bytes.NewReader(env.Data)
+	// do not wait forever for the output of orphaned grandchildren
+	cmd.WaitDelay = 5 * time.Second
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd)
+	}
+
+	stdout := &lineLogger{log: func(line string) { logInfo.Printf("message %q stdout: %s\n", msg.Id(), line) }}
+	stderr := &lineLogger{log: func(line string) { logErr.Printf("message %q stderr: %s\n", msg.Id(), line) }}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	err = cmd.Run()
+	stdout.Flush()
+	stderr.Flush()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if errors.Is(cmdCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: command timed out", ErrFail)
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return fmt.Errorf("%w: %w", ErrFail, err)
+	}
+
+	code := cmd.ProcessState.ExitCode()
+	if so, ok := lookupOutcome(e.config.ExitOutcomes, code); ok {
+		return so.Outcome.Err(time.Duration(so.Delay)*time.Second, fmt.Sprintf("command exit code %d", code))
+	}
+
+	if code != 0 {
+		return e.config.DefaultOutcome.Err(0, fmt.Sprintf("command exit code %d", code))
+	}
+
+	return nil
+}
+
+// messageEnv returns message metadata as environment variables, attribute
+// names are upper-cased with non-alphanumeric characters replaced by '_'
+func messageEnv(msgEnv transform.Envelope) []string {
+	env := []string{
+		ExecEnvPrefix + "MESSAGE_ID=" + msgEnv.MessageId,
+		ExecEnvPrefix + "QUEUE_ID=" + msgEnv.QueueId,
+	}
+
+	for name, value := range msgEnv.Attributes {
+		env = append(env, ExecEnvPrefix+"ATTR_"+envName(name)+"="+value)
+	}
+
+	return env
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// lineLogger logs the command output line by line
+type lineLogger struct {
+	log func(line string)
+	buf []byte
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.log(string(bytes.TrimRight(l.buf[:i], "\r")))
+		l.buf = l.buf[i+1:]
+	}
+
+	return len(p), nil
+}
+
+// Flush logs the last line not terminated with the new line
+func (l *lineLogger) Flush() {
+	if len(l.buf) > 0 {
+		l.log(string(l.buf))
+		l.buf = nil
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"github.com/Burmuley/priority-pubsub/subscriber"
+	"github.com/Burmuley/priority-pubsub/transform"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"time"
+)
+
+const (
+	GrpcDefaultTimeout = 120
+)
+
+type GrpcConfig struct {
+	// Address of the subscriber, e.g. "localhost:5001" or "unix:///var/run/app.sock"
+	Address   string        `koanf:"address"`
+	Timeout   int           `koanf:"timeout"`
+	Plaintext bool          `koanf:"plaintext"`
+	TLS       HttpTLSConfig `koanf:"tls"`
+}
+
+type Grpc struct {
+	config GrpcConfig
+	conn   *grpc.ClientConn
+	client subscriber.SubscriberClient
+}
+
+func NewGrpc(config GrpcConfig) (*Grpc, error) {
+	if config.Address == "" {
+		return nil, fmt.Errorf("%w: field 'address' is mandatory", ErrConfig)
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = GrpcDefaultTimeout
+	}
+
+	creds := insecure.NewCredentials()
+	if !config.Plaintext {
+		tlsConfig, err := newTLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+
+	// connection is established lazily and re-established on failures
+	conn, err := grpc.Dial(config.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+
+	return &Grpc{
+		config: config,
+		conn:   conn,
+		client: subscriber.NewSubscriberClient(conn),
+	}, nil
+}
+
+func (g *Grpc) Run(ctx context.Context, msg queue.Message, trans transform.Transformer) error {
+	env, err := transformMessage(msg, trans)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, time.Duration(g.config.Timeout)*time.Second)
+	defer cancel()
+
+	resp, err := g.client.Deliver(callCtx, &subscriber.DeliverRequest{
+		Id:         msg.Id(),
+		Queue:      msg.QueueId(),
+		Attributes: env.Attributes,
+		Data:       env.Data,
+	})
+
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFail, err)
+	}
+
+	switch resp.GetStatus() {
+	case subscriber.DeliverResponse_STATUS_ACK:
+		return nil
+	case subscriber.DeliverResponse_STATUS_UNSPECIFIED:
+		// an empty response must not delete the message
+		return OutcomeRetry.Err(0, "subscriber response status is not set")
+	case subscriber.DeliverResponse_STATUS_RETRY:
+		if delay := resp.GetRetryDelaySeconds(); delay > 0 {
+			return OutcomeRetryDelay.Err(time.Duration(delay)*time.Second, "subscriber requested retry")
+		}
+		return OutcomeRetry.Err(0, "subscriber requested retry")
+	case subscriber.DeliverResponse_STATUS_DROP:
+		return OutcomeFatal.Err(0, "subscriber requested drop")
+	case subscriber.DeliverResponse_STATUS_DEAD_LETTER:
+		return OutcomeDeadLetter.Err(0, "subscriber requested dead-lettering")
+	}
+
+	return fmt.Errorf("%w: unknown response status %q", ErrFail, resp.GetStatus())
+}
@@ -19,11 +19,15 @@ package process
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/Burmuley/priority-pubsub/queue"
 	"github.com/Burmuley/priority-pubsub/transform"
+	"io"
 	"net/http"
 	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,18 +35,33 @@ const (
 	HttpDefaultMethod      = "POST"
 	HttpDefaultTimeout     = 120
 	HttpDefaultContentType = "text/plain"
+	HttpMaxReplySize       = 10 << 20
 )
 
 type HttpConfig struct {
-	SubscriberUrl string `koanf:"subscriber_url"`
-	Method        string `koanf:"method"`
-	Timeout       int    `koanf:"timeout"`
-	FatalCodes    []int  `koanf:"fatal_codes"`
-	ContentType   string `koanf:"content_type"`
+	SubscriberUrl  string              `koanf:"subscriber_url"`
+	Method         string              `koanf:"method"`
+	Timeout        int                 `koanf:"timeout"`
+	FatalCodes     []int               `koanf:"fatal_codes"`
+	ContentType    string              `koanf:"content_type"`
+	StatusOutcomes []StatusOutcome     `koanf:"status_outcomes"`
+	DefaultOutcome Outcome             `koanf:"default_outcome"`
+	Transport      HttpTransportConfig `koanf:"transport"`
+	Auth           HttpAuthConfig      `koanf:"auth"`
+	TLS            HttpTLSConfig       `koanf:"tls"`
+	Async          HttpAsyncConfig     `koanf:"async"`
+	CloudEvents    CloudEventsConfig   `koanf:"cloudevents"`
+	// BodyTemplate is the text/template the request body is built with
+	BodyTemplate string `koanf:"body_template"`
 }
 
 type Http struct {
-	config HttpConfig
+	config      HttpConfig
+	client      *http.Client
+	auth        httpAuth
+	async       *httpAsync
+	cloudEvents *cloudEventsEncoder
+	templates   *httpTemplates
 }
 
 func NewHttp(config HttpConfig) (*Http, error) {
@@ -68,72 +87,276 @@ func NewHttp(config HttpConfig) (*Http, error) {
 		config.ContentType = HttpDefaultContentType
 	}
 
+	if config.DefaultOutcome == "" {
+		config.DefaultOutcome = OutcomeRetry
+	}
+
+	if err := config.DefaultOutcome.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.DefaultOutcome == OutcomeRetryDelay {
+		return nil, fmt.Errorf("%w: 'default_outcome' can not be %q, use 'status_outcomes' instead", ErrConfig, config.DefaultOutcome)
+	}
+
+	for i := range config.StatusOutcomes {
+		if err := config.StatusOutcomes[i].parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	tlsConfig, err := newTLSConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriberUrl, socket, err := parseSubscriberUrl(config.SubscriberUrl)
+	if err != nil {
+		return nil, err
+	}
+	config.SubscriberUrl = subscriberUrl
+
+	if config.Transport.H2C && strings.HasPrefix(strings.ToLower(subscriberUrl), "https://") {
+		return nil, fmt.Errorf("%w: 'h2c' can not be used with https subscriber URLs", ErrConfig)
+	}
+
+	transport, err := newHttpTransport(config.Transport, tlsConfig, socket)
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := newHttpAuth(config.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	async, err := newHttpAsync(config.Async)
+	if err != nil {
+		return nil, err
+	}
+
+	cloudEvents, err := newCloudEventsEncoder(config.CloudEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := newHttpTemplates(config.SubscriberUrl, config.BodyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
 	raw := &Http{
 		config: config,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(config.Timeout) * time.Second,
+		},
+		auth:        auth,
+		async:       async,
+		cloudEvents: cloudEvents,
+		templates:   templates,
 	}
 
 	return raw, nil
 }
 
-func (r *Http) Run(ctx context.Context, msg queue.Message, trans transform.TransformationFunc) error {
-	resChan := make(chan error)
-	data := msg.Data()
+func (r *Http) Run(ctx context.Context, msg queue.Message, trans transform.Transformer) error {
+	env, err := transformMessage(msg, trans)
+	if err != nil {
+		return err
+	}
+
+	resChan := make(chan error, 1)
+	go func() {
+		resChan <- r.send(ctx, msg, env)
+	}()
+
+	select {
+	case res := <-resChan:
+		return res
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Http) send(ctx context.Context, msg queue.Message, env transform.Envelope) error {
+	if env.ContentType == "" {
+		env.ContentType = r.config.ContentType
+	}
 
-	{
+	subscriberUrl := r.config.SubscriberUrl
+	if r.templates != nil {
 		var err error
-		if trans != nil {
-			data, err = trans(data)
-			if err != nil {
-				return fmt.Errorf("%w: %w", ErrFatal, err)
-			}
+		if subscriberUrl, env.Data, err = r.templates.render(subscriberUrl, env); err != nil {
+			return fmt.Errorf("%w: error rendering template: %w", ErrFatal, err)
 		}
 	}
 
-	go func() {
-		client := http.Client{
-			Timeout: time.Duration(r.config.Timeout) * time.Second,
+	data := env.Data
+	header := http.Header{}
+	header.Set("Content-Type", env.ContentType)
+
+	if r.cloudEvents != nil {
+		var err error
+		if header, data, err = r.cloudEvents.encode(env); err != nil {
+			return fmt.Errorf("%w: %w", ErrFatal, err)
 		}
+	}
 
-		req, err := http.NewRequestWithContext(ctx, r.config.Method, r.config.SubscriberUrl, bytes.NewBuffer(data))
-		if err != nil {
-			resChan <- fmt.Errorf("%w: %q", ErrFatal, err.Error())
-			close(resChan)
-			return
+	for name, value := range env.Headers {
+		header.Set(name, value)
+	}
+
+	var token string
+	if r.async != nil {
+		var err error
+		if token, err = r.async.register(); err != nil {
+			return fmt.Errorf("%w: error issuing callback token: %w", ErrFail, err)
 		}
-		req.Header.Add("content-type", r.config.ContentType)
+		defer r.async.release(token)
+		r.async.setHeaders(header, token)
+	}
+
+	resp, err := r.do(ctx, r.config.Method, subscriberUrl, header, data)
+	if err != nil {
+		return err
+	}
+
+	if r.async != nil && resp.StatusCode == http.StatusAccepted {
+		drainBody(resp)
+		logInfo.Printf("message %q accepted for asynchronous processing\n", msg.Id())
+		return r.async.wait(ctx, msg, token)
+	}
 
-		resp, err := client.Do(req)
-		if err != nil {
-			resChan <- fmt.Errorf("%w: %q", ErrFail, err.Error())
-			close(resChan)
-			return
+	if err := r.responseError(resp); err != nil {
+		drainBody(resp)
+		return err
+	}
+
+	return r.reply(msg, resp)
+}
+
+// reply sends the response body to the reply destination of the message queue, if any
+func (r *Http) reply(msg queue.Message, resp *http.Response) error {
+	defer drainBody(resp)
+
+	// the body is not read at all when there is nowhere to send it
+	replier, ok := msg.(queue.Replier)
+	if !ok || !replier.CanReply() {
+		return nil
+	}
+
+	// one extra byte is read to tell the oversized reply from the one of the maximum size
+	body, err := io.ReadAll(io.LimitReader(resp.Body, HttpMaxReplySize+1))
+	if err != nil {
+		return fmt.Errorf("%w: error reading response: %w", ErrFail, err)
+	}
+
+	if len(body) > HttpMaxReplySize {
+		return fmt.Errorf("%w: reply exceeds the maximum size of %d bytes", ErrFail, HttpMaxReplySize)
+	}
+
+	if len(body) == 0 {
+		return nil
+	}
+
+	attributes := make(map[string]string)
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		attributes["content_type"] = contentType
+	}
+
+	if err := replier.Reply(body, attributes); err != nil {
+		if errors.Is(err, queue.ErrNoReply) {
+			return nil
 		}
-		req.Header.Add("content-type", r.config.ContentType)
+		// the message is returned, so the reply is not lost
+		return fmt.Errorf("%w: %w", ErrFail, err)
+	}
+
+	logInfo.Printf("reply to message %q from %q has been sent\n", msg.Id(), msg.QueueId())
+	return nil
+}
 
-		if slices.Contains(r.config.FatalCodes, resp.StatusCode) {
-			resChan <- fmt.Errorf("%w: response status code %q", ErrFatal, resp.StatusCode)
-			close(resChan)
-			return
+// do sends the request to the subscriber adding authentication headers
+func (r *Http) do(ctx context.Context, method, url string, header http.Header, data []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrFatal, err.Error())
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	if r.auth != nil {
+		if err := r.auth.authorize(req, data); err != nil {
+			return nil, fmt.Errorf("%w: authentication error: %w", ErrFail, err)
 		}
+	}
 
-		if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-			resChan <- fmt.Errorf("%w: task execution has failed", ErrFail)
-			close(resChan)
-			return
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrFail, err.Error())
+	}
+
+	return resp, nil
+}
+
+// responseError converts the subscriber response into the processing result
+func (r *Http) responseError(resp *http.Response) error {
+	if so, ok := lookupOutcome(r.config.StatusOutcomes, resp.StatusCode); ok {
+		return so.Outcome.Err(time.Duration(so.Delay)*time.Second, fmt.Sprintf("response status code %d", resp.StatusCode))
+	}
+
+	if slices.Contains(r.config.FatalCodes, resp.StatusCode) {
+		return fmt.Errorf("%w: response status code %d", ErrFatal, resp.StatusCode)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return &DelayError{
+				Err:   fmt.Errorf("%w: response status code %d", ErrThrottle, resp.StatusCode),
+				Delay: delay,
+			}
 		}
+	}
 
-		resChan <- nil
-		close(resChan)
-	}()
+	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return r.config.DefaultOutcome.Err(0, "task execution has failed")
+	}
 
-	for {
-		select {
-		case res := <-resChan:
-			return res
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			time.Sleep(5 * time.Second)
+	return nil
+}
+
+// drainBody reads the rest of the response body and closes it, so the connection can be reused
+func drainBody(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+// parseRetryAfter parses value of the `Retry-After` HTTP header which can be
+// either a number of seconds or an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
 		}
+		return time.Duration(seconds) * time.Second, true
 	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	delay := time.Until(date)
+	if delay < 0 {
+		delay = 0
+	}
+
+	return delay, true
 }
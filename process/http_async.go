@@ -0,0 +1,309 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HttpAsyncDefaultListenAddress  = "127.0.0.1:8090"
+	HttpAsyncDefaultLeaseExtension = 60
+	// AWS SQS does not extend visibility of messages received more than 12 hours ago
+	HttpAsyncDefaultCompletionTimeout = 43200
+	HttpAsyncTokenHeader              = "X-Callback-Token"
+	HttpAsyncCallbackHeader           = "X-Callback-Url"
+
+	asyncCallbackPath      = "/complete/"
+	asyncTokenSize         = 32
+	asyncReadHeaderTimeout = 10 * time.Second
+	asyncReadTimeout       = 30 * time.Second
+	asyncWriteTimeout      = 30 * time.Second
+	asyncIdleTimeout       = 120 * time.Second
+	asyncShutdownTimeout   = 10 * time.Second
+)
+
+// HttpAsyncConfig enables asynchronous processing: the subscriber replies 202 Accepted
+// and later reports the result to the callback endpoint
+type HttpAsyncConfig struct {
+	Enabled       bool   `koanf:"enabled"`
+	ListenAddress string `koanf:"listen_address"`
+	// CallbackUrl is the base URL of the callback endpoint reachable by the subscriber,
+	// passed to the subscriber in the X-Callback-Url header
+	CallbackUrl string `koanf:"callback_url"`
+	// LeaseExtension is the time in seconds the message lease is extended for at a time
+	LeaseExtension    int `koanf:"lease_extension"`
+	CompletionTimeout int `koanf:"completion_timeout"`
+}
+
+// asyncCompletion is the body of the callback request
+type asyncCompletion struct {
+	// Status is one of "success", "retry", "fatal" or "dead_letter"
+	Status string `json:"status"`
+}
+
+var asyncStatuses = map[string]Outcome{
+	"success":     OutcomeAck,
+	"retry":       OutcomeRetry,
+	"fatal":       OutcomeFatal,
+	"dead_letter": OutcomeDeadLetter,
+}
+
+type httpAsync struct {
+	config HttpAsyncConfig
+	server *asyncServer
+}
+
+// asyncServer is the callback endpoint shared by all processors listening on the same address
+type asyncServer struct {
+	server   *http.Server
+	listener net.Listener
+	mu       sync.Mutex
+	pending  map[string]chan Outcome
+}
+
+var (
+	asyncServersMu sync.Mutex
+	asyncServers   = make(map[string]*asyncServer)
+)
+
+func newHttpAsync(config HttpAsyncConfig) (*httpAsync, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	if config.ListenAddress == "" {
+		config.ListenAddress = HttpAsyncDefaultListenAddress
+	}
+
+	if config.LeaseExtension == 0 {
+		config.LeaseExtension = HttpAsyncDefaultLeaseExtension
+	}
+
+	if config.CompletionTimeout == 0 {
+		config.CompletionTimeout = HttpAsyncDefaultCompletionTimeout
+	}
+
+	if config.LeaseExtension < 0 || config.CompletionTimeout < 0 {
+		return nil, fmt.Errorf("%w: 'lease_extension' and 'completion_timeout' should be positive", ErrConfig)
+	}
+
+	// longer leases are capped by GCP Pub/Sub, so the lease would expire between extensions
+	if time.Duration(config.LeaseExtension)*time.Second > queue.GcpPubSubMaxAckDeadline {
+		return nil, fmt.Errorf("%w: 'lease_extension' should not exceed %d seconds", ErrConfig, int(queue.GcpPubSubMaxAckDeadline.Seconds()))
+	}
+
+	server, err := getAsyncServer(config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpAsync{config: config, server: server}, nil
+}
+
+// getAsyncServer returns the callback server listening on the address, starting it
+// on the first use
+func getAsyncServer(address string) (*asyncServer, error) {
+	asyncServersMu.Lock()
+	defer asyncServersMu.Unlock()
+
+	if server, ok := asyncServers[address]; ok {
+		return server, nil
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error starting callback listener: %w", ErrConfig, err)
+	}
+
+	server := &asyncServer{listener: listener, pending: make(map[string]chan Outcome)}
+	mux := http.NewServeMux()
+	mux.HandleFunc(asyncCallbackPath, server.handleCallback)
+
+	// slow clients should not hold connections forever
+	server.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: asyncReadHeaderTimeout,
+		ReadTimeout:       asyncReadTimeout,
+		WriteTimeout:      asyncWriteTimeout,
+		IdleTimeout:       asyncIdleTimeout,
+	}
+
+	go func() {
+		logInfo.Printf("listening for completion callbacks on %s\n", listener.Addr())
+		if err := server.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logErr.Printf("callback listener error: %s\n", err)
+		}
+	}()
+
+	asyncServers[address] = server
+	return server, nil
+}
+
+// ShutdownCallbackServers stops completion callback listeners of all asynchronous processors,
+// it should be called once processing has stopped
+func ShutdownCallbackServers() {
+	asyncServersMu.Lock()
+	defer asyncServersMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), asyncShutdownTimeout)
+	defer cancel()
+
+	for address, server := range asyncServers {
+		if err := server.server.Shutdown(ctx); err != nil {
+			logErr.Printf("error shutting down callback listener on %s: %s\n", address, err)
+		}
+		// Serve may not have started yet, so the listener is not tracked by the server
+		_ = server.listener.Close()
+		delete(asyncServers, address)
+	}
+}
+
+// register issues an unguessable token for the request, only callbacks with
+// registered tokens are accepted
+func (a *httpAsync) register() (string, error) {
+	raw := make([]byte, asyncTokenSize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	a.server.mu.Lock()
+	defer a.server.mu.Unlock()
+
+	// buffered, so the callback may arrive before the 202 response is handled
+	a.server.pending[token] = make(chan Outcome, 1)
+	return token, nil
+}
+
+func (a *httpAsync) release(token string) {
+	a.server.mu.Lock()
+	defer a.server.mu.Unlock()
+
+	delete(a.server.pending, token)
+}
+
+// setHeaders passes the token and the callback endpoint to the subscriber
+func (a *httpAsync) setHeaders(header http.Header, token string) {
+	header.Set(HttpAsyncTokenHeader, token)
+	if a.config.CallbackUrl != "" {
+		header.Set(HttpAsyncCallbackHeader, strings.TrimSuffix(a.config.CallbackUrl, "/")+asyncCallbackPath+token)
+	}
+}
+
+// wait blocks until the subscriber reports the result for the token, the message
+// lease is extended while waiting
+func (a *httpAsync) wait(ctx context.Context, msg queue.Message, token string) error {
+	a.server.mu.Lock()
+	result := a.server.pending[token]
+	a.server.mu.Unlock()
+
+	lease := time.Duration(a.config.LeaseExtension) * time.Second
+	extender, canExtend := msg.(queue.LeaseExtender)
+	if !canExtend {
+		logErr.Printf("lease of message %q can not be extended\n", msg.Id())
+	}
+
+	extend := func() {
+		if !canExtend {
+			return
+		}
+		if err := extender.ExtendLease(lease); err != nil {
+			logErr.Printf("error extending lease of message %q: %s\n", msg.Id(), err)
+		}
+	}
+
+	extend()
+	ticker := time.NewTicker(lease / 2)
+	defer ticker.Stop()
+
+	timeout := time.NewTimer(time.Duration(a.config.CompletionTimeout) * time.Second)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case outcome := <-result:
+			return outcome.Err(0, fmt.Sprintf("subscriber reported %q for message %q", outcome, msg.Id()))
+		case <-ticker.C:
+			extend()
+		case <-timeout.C:
+			return fmt.Errorf("%w: no completion received for message %q", ErrFail, msg.Id())
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// complete delivers the outcome to the waiter, false is returned for unknown tokens
+func (s *asyncServer) complete(token string, outcome Outcome) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result, ok := s.pending[token]
+	if !ok {
+		return false
+	}
+
+	select {
+	case result <- outcome:
+	default:
+		logErr.Printf("duplicate completion ignored\n")
+	}
+
+	return true
+}
+
+func (s *asyncServer) handleCallback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var completion asyncCompletion
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&completion); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	outcome, ok := asyncStatuses[completion.Status]
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown status %q", completion.Status), http.StatusBadRequest)
+		return
+	}
+
+	// tokens are secrets, so they are not logged
+	token := strings.TrimPrefix(r.URL.Path, asyncCallbackPath)
+	if !s.complete(token, outcome) {
+		http.Error(w, "unknown token", http.StatusNotFound)
+		return
+	}
+
+	logInfo.Printf("completion %q received\n", completion.Status)
+	w.WriteHeader(http.StatusOK)
+}
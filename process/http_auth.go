@@ -0,0 +1,226 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HttpDefaultSignatureHeader = "X-Signature"
+	HttpDefaultTimestampHeader = "X-Signature-Timestamp"
+)
+
+// HttpAuthConfig defines how requests to the subscriber are authenticated
+type HttpAuthConfig struct {
+	// Type is one of "bearer", "oauth2" or "hmac"
+	Type string `koanf:"type"`
+
+	// static bearer token
+	TokenFile string `koanf:"token_file"`
+	TokenEnv  string `koanf:"token_env"`
+
+	// OAuth2 client credentials flow
+	TokenUrl         string   `koanf:"token_url"`
+	ClientId         string   `koanf:"client_id"`
+	ClientSecretFile string   `koanf:"client_secret_file"`
+	ClientSecretEnv  string   `koanf:"client_secret_env"`
+	Scopes           []string `koanf:"scopes"`
+
+	// HMAC-SHA256 request signing
+	SecretFile      string `koanf:"secret_file"`
+	SecretEnv       string `koanf:"secret_env"`
+	SignatureHeader string `koanf:"signature_header"`
+	TimestampHeader string `koanf:"timestamp_header"`
+}
+
+// httpAuth adds authentication data to the request before it's sent to the subscriber
+type httpAuth interface {
+	authorize(req *http.Request, body []byte) error
+}
+
+func newHttpAuth(config HttpAuthConfig) (httpAuth, error) {
+	switch config.Type {
+	case "":
+		return nil, nil
+	case "bearer":
+		token, err := newSecret("token", config.TokenFile, config.TokenEnv)
+		if err != nil {
+			return nil, err
+		}
+		return &bearerAuth{token: token}, nil
+	case "oauth2":
+		if config.TokenUrl == "" || config.ClientId == "" {
+			return nil, fmt.Errorf("%w: fields 'token_url' and 'client_id' are mandatory for %q authentication", ErrConfig, config.Type)
+		}
+
+		secret, err := newSecret("client_secret", config.ClientSecretFile, config.ClientSecretEnv)
+		if err != nil {
+			return nil, err
+		}
+
+		clientSecret, err := secret.value()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+
+		ccConfig := &clientcredentials.Config{
+			ClientID:     config.ClientId,
+			ClientSecret: clientSecret,
+			TokenURL:     config.TokenUrl,
+			Scopes:       config.Scopes,
+		}
+
+		// token source caches the token until it expires
+		return &oauth2Auth{tokens: ccConfig.TokenSource(context.Background())}, nil
+	case "hmac":
+		secret, err := newSecret("secret", config.SecretFile, config.SecretEnv)
+		if err != nil {
+			return nil, err
+		}
+
+		if config.SignatureHeader == "" {
+			config.SignatureHeader = HttpDefaultSignatureHeader
+		}
+
+		if config.TimestampHeader == "" {
+			config.TimestampHeader = HttpDefaultTimestampHeader
+		}
+
+		return &hmacAuth{
+			secret:          secret,
+			signatureHeader: config.SignatureHeader,
+			timestampHeader: config.TimestampHeader,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: authentication type %q is not supported", ErrConfig, config.Type)
+}
+
+type bearerAuth struct {
+	token *secret
+}
+
+func (a *bearerAuth) authorize(req *http.Request, _ []byte) error {
+	token, err := a.token.value()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+type oauth2Auth struct {
+	tokens oauth2.TokenSource
+}
+
+func (a *oauth2Auth) authorize(req *http.Request, _ []byte) error {
+	token, err := a.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("error obtaining OAuth2 token: %w", err)
+	}
+
+	token.SetAuthHeader(req)
+	return nil
+}
+
+// hmacAuth signs requests with HMAC-SHA256 of "<timestamp>.<body>", so
+// the subscriber can verify the request origin and reject replayed requests
+type hmacAuth struct {
+	secret          *secret
+	signatureHeader string
+	timestampHeader string
+}
+
+func (a *hmacAuth) authorize(req *http.Request, body []byte) error {
+	key, err := a.secret.value()
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	req.Header.Set(a.timestampHeader, timestamp)
+	req.Header.Set(a.signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+// secret is read either from the environment variable or from the file,
+// the file is re-read when it's modified on disk
+type secret struct {
+	file string
+	env  string
+
+	mu      sync.Mutex
+	modTime time.Time
+	cached  string
+}
+
+func newSecret(name, file, env string) (*secret, error) {
+	if (file == "") == (env == "") {
+		return nil, fmt.Errorf("%w: exactly one of '%s_file' or '%s_env' should be set", ErrConfig, name, name)
+	}
+
+	return &secret{file: file, env: env}, nil
+}
+
+func (s *secret) value() (string, error) {
+	if s.env != "" {
+		value, ok := os.LookupEnv(s.env)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", s.env)
+		}
+		return value, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.file)
+	if err != nil {
+		return "", err
+	}
+
+	if !info.ModTime().Equal(s.modTime) {
+		data, err := os.ReadFile(s.file)
+		if err != nil {
+			return "", err
+		}
+
+		s.cached = strings.TrimSpace(string(data))
+		s.modTime = info.ModTime()
+	}
+
+	return s.cached, nil
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/transform"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// httpTemplateData is the data subscriber URL and body templates are executed with
+type httpTemplateData struct {
+	Id         string
+	QueueId    string
+	Attributes map[string]string
+	// Data is the message data as string
+	Data string
+	// Json is the decoded message data, nil if the data is not valid JSON
+	Json any
+}
+
+var httpTemplateFuncs = template.FuncMap{
+	"toJson": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"pathEscape": pathEscape,
+}
+
+// pathEscape escapes the value to be used as a URL path segment, dot segments
+// are escaped as well, so the value can not point to the parent path
+func pathEscape(value string) string {
+	if value == "." || value == ".." {
+		return strings.Repeat("%2E", len(value))
+	}
+	return url.PathEscape(value)
+}
+
+// httpTemplates renders the subscriber URL and the request body from the message envelope
+type httpTemplates struct {
+	url  *template.Template
+	body *template.Template
+}
+
+// newHttpTemplates parses templates, nil is returned when neither the URL nor the body is templated;
+// missing attributes and JSON fields fail the rendering instead of producing "<no value>"
+func newHttpTemplates(subscriberUrl, bodyTemplate string) (*httpTemplates, error) {
+	t := &httpTemplates{}
+
+	if strings.Contains(subscriberUrl, "{{") {
+		tmpl, err := template.New("subscriber_url").Funcs(httpTemplateFuncs).Option("missingkey=error").Parse(subscriberUrl)
+		if err != nil {
+			return nil, fmt.Errorf("%w: error parsing 'subscriber_url' template: %w", ErrConfig, err)
+		}
+		t.url = tmpl
+	}
+
+	if bodyTemplate != "" {
+		tmpl, err := template.New("body_template").Funcs(httpTemplateFuncs).Option("missingkey=error").Parse(bodyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("%w: error parsing 'body_template': %w", ErrConfig, err)
+		}
+		t.body = tmpl
+	}
+
+	if t.url == nil && t.body == nil {
+		return nil, nil
+	}
+
+	return t, nil
+}
+
+// render returns the subscriber URL and the request body for the envelope,
+// defaults are returned for the parts which are not templated
+func (t *httpTemplates) render(subscriberUrl string, env transform.Envelope) (string, []byte, error) {
+	data := httpTemplateData{
+		Id:         env.MessageId,
+		QueueId:    env.QueueId,
+		Attributes: env.Attributes,
+		Data:       string(env.Data),
+	}
+
+	// numbers are kept as json.Number, so they are rendered as in the message
+	var payload any
+	decoder := json.NewDecoder(bytes.NewReader(env.Data))
+	decoder.UseNumber()
+	if decoder.Decode(&payload) == nil {
+		data.Json = payload
+	}
+
+	body := env.Data
+	if t.url != nil {
+		buf := &bytes.Buffer{}
+		if err := t.url.Execute(buf, data); err != nil {
+			return "", nil, err
+		}
+		subscriberUrl = buf.String()
+	}
+
+	if t.body != nil {
+		buf := &bytes.Buffer{}
+		if err := t.body.Execute(buf, data); err != nil {
+			return "", nil, err
+		}
+		body = buf.Bytes()
+	}
+
+	return subscriberUrl, body, nil
+}
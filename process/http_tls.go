@@ -0,0 +1,215 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// HttpTLSConfig defines TLS settings for connections to the subscriber,
+// certificate files are reloaded when they are modified on disk
+type HttpTLSConfig struct {
+	CaFile             string `koanf:"ca_file"`
+	CertFile           string `koanf:"cert_file"`
+	KeyFile            string `koanf:"key_file"`
+	ServerName         string `koanf:"server_name"`
+	MinVersion         string `koanf:"min_version"`
+	InsecureSkipVerify bool   `koanf:"insecure_skip_verify"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func newTLSConfig(config HttpTLSConfig) (*tls.Config, error) {
+	if config.MinVersion == "" {
+		config.MinVersion = "1.2"
+	}
+
+	minVersion, ok := tlsVersions[config.MinVersion]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported TLS version %q", ErrConfig, config.MinVersion)
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, fmt.Errorf("%w: both 'cert_file' and 'key_file' should be set", ErrConfig)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	reloader := &certReloader{
+		caFile:     config.CaFile,
+		certFile:   config.CertFile,
+		keyFile:    config.KeyFile,
+		serverName: config.ServerName,
+	}
+
+	if config.CertFile != "" {
+		if _, err := reloader.certificate(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.certificate()
+		}
+	}
+
+	if config.CaFile != "" && !config.InsecureSkipVerify {
+		if _, err := reloader.rootCAs(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+		}
+
+		// RootCAs can not be replaced on a live config, so the default verification
+		// is disabled and replaced with the one using the current CA bundle
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = reloader.verifyConnection
+	}
+
+	return tlsConfig, nil
+}
+
+type certReloader struct {
+	caFile   string
+	certFile string
+	keyFile  string
+	// serverName overrides the name the server certificate is verified against
+	serverName string
+
+	mu        sync.Mutex
+	caModTime time.Time
+	pool      *x509.CertPool
+	certMod   time.Time
+	keyMod    time.Time
+	cert      *tls.Certificate
+}
+
+func (c *certReloader) certificate() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	certMod, err := modTime(c.certFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keyMod, err := modTime(c.keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cert != nil && certMod.Equal(c.certMod) && keyMod.Equal(c.keyMod) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		// certificate and key may be in the middle of rotation, keep the previous pair
+		if c.cert != nil {
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("error loading client certificate: %w", err)
+	}
+
+	c.cert, c.certMod, c.keyMod = &cert, certMod, keyMod
+	return c.cert, nil
+}
+
+func (c *certReloader) rootCAs() (*x509.CertPool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	caMod, err := modTime(c.caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.pool != nil && caMod.Equal(c.caModTime) {
+		return c.pool, nil
+	}
+
+	data, err := os.ReadFile(c.caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		if c.pool != nil {
+			return c.pool, nil
+		}
+		return nil, fmt.Errorf("no certificates found in %q", c.caFile)
+	}
+
+	c.pool, c.caModTime = pool, caMod
+	return c.pool, nil
+}
+
+func (c *certReloader) verifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificates presented")
+	}
+
+	// the SNI name is empty for IP address hosts, the certificate can not be checked
+	// against the host then, so the connection is rejected instead
+	name := c.serverName
+	if name == "" {
+		name = cs.ServerName
+	}
+	if name == "" {
+		return errors.New("server name is unknown, set 'server_name' to verify the server certificate")
+	}
+
+	roots, err := c.rootCAs()
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+func modTime(file string) (time.Time, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return info.ModTime(), nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	HttpDefaultMaxIdleConns        = 100
+	HttpDefaultMaxIdleConnsPerHost = 16
+	HttpDefaultIdleConnTimeout     = 90
+	HttpDefaultKeepAlive           = 30
+)
+
+const unixScheme = "unix://"
+
+// HttpTransportConfig tunes the transport shared by all requests of the HTTP processor
+type HttpTransportConfig struct {
+	MaxIdleConns        int  `koanf:"max_idle_conns"`
+	MaxIdleConnsPerHost int  `koanf:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int  `koanf:"max_conns_per_host"`
+	IdleConnTimeout     int  `koanf:"idle_conn_timeout"`
+	KeepAlive           int  `koanf:"keep_alive"`
+	DisableKeepAlives   bool `koanf:"disable_keep_alives"`
+	DisableHttp2        bool `koanf:"disable_http2"`
+	// H2C enables HTTP/2 over cleartext connections (prior knowledge)
+	H2C bool `koanf:"h2c"`
+}
+
+// parseSubscriberUrl converts "unix:///path/to/app.sock:/request/path" URLs into
+// the socket path and the HTTP URL to send requests to, other URLs are returned as is
+func parseSubscriberUrl(subscriberUrl string) (string, string, error) {
+	if !strings.HasPrefix(subscriberUrl, unixScheme) {
+		return subscriberUrl, "", nil
+	}
+
+	socket, path, _ := strings.Cut(strings.TrimPrefix(subscriberUrl, unixScheme), ":")
+	if socket == "" {
+		return "", "", fmt.Errorf("%w: socket path is missing in %q", ErrConfig, subscriberUrl)
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return "http://localhost" + path, socket, nil
+}
+
+func newHttpTransport(config HttpTransportConfig, tlsConfig *tls.Config, socket string) (http.RoundTripper, error) {
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = HttpDefaultMaxIdleConns
+	}
+
+	if config.MaxIdleConnsPerHost == 0 {
+		config.MaxIdleConnsPerHost = HttpDefaultMaxIdleConnsPerHost
+	}
+
+	if config.IdleConnTimeout == 0 {
+		config.IdleConnTimeout = HttpDefaultIdleConnTimeout
+	}
+
+	if config.KeepAlive == 0 {
+		config.KeepAlive = HttpDefaultKeepAlive
+	}
+
+	if config.H2C && config.DisableHttp2 {
+		return nil, fmt.Errorf("%w: 'h2c' and 'disable_http2' are mutually exclusive", ErrConfig)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: time.Duration(config.KeepAlive) * time.Second,
+	}
+
+	dial := dialer.DialContext
+	proxy := http.ProxyFromEnvironment
+	if socket != "" {
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		proxy = nil
+	}
+
+	if config.H2C {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+			// health check the idle connections the same way TCP keep-alive does
+			ReadIdleTimeout: time.Duration(config.KeepAlive) * time.Second,
+		}, nil
+	}
+
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     !config.DisableHttp2,
+		MaxIdleConns:          config.MaxIdleConns,
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       config.MaxConnsPerHost,
+		IdleConnTimeout:       time.Duration(config.IdleConnTimeout) * time.Second,
+		DisableKeepAlives:     config.DisableKeepAlives,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	if config.DisableHttp2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return transport, nil
+}
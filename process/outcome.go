@@ -0,0 +1,113 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcome defines what should happen to the message after processing
+type Outcome string
+
+const (
+	OutcomeAck        Outcome = "ack"
+	OutcomeRetry      Outcome = "retry"
+	OutcomeRetryDelay Outcome = "retry_delay"
+	OutcomeFatal      Outcome = "fatal"
+	OutcomeDeadLetter Outcome = "dead_letter"
+)
+
+func (o Outcome) validate() error {
+	switch o {
+	case OutcomeAck, OutcomeRetry, OutcomeRetryDelay, OutcomeFatal, OutcomeDeadLetter:
+		return nil
+	}
+
+	return fmt.Errorf("%w: unknown outcome %q", ErrConfig, o)
+}
+
+// Err converts the outcome into the error kind expected by pollers,
+// returns nil for OutcomeAck
+func (o Outcome) Err(delay time.Duration, reason string) error {
+	switch o {
+	case OutcomeAck:
+		return nil
+	case OutcomeRetryDelay:
+		return &DelayError{Err: fmt.Errorf("%w: %s", ErrRetryDelay, reason), Delay: delay}
+	case OutcomeFatal:
+		return fmt.Errorf("%w: %s", ErrFatal, reason)
+	case OutcomeDeadLetter:
+		return fmt.Errorf("%w: %s", ErrDeadLetter, reason)
+	}
+
+	return fmt.Errorf("%w: %s", ErrFail, reason)
+}
+
+// StatusOutcome maps a status code or a range of codes to an outcome
+type StatusOutcome struct {
+	// Codes is a single code, e.g. "409", or an inclusive range, e.g. "500-599"
+	Codes   string  `koanf:"codes"`
+	Outcome Outcome `koanf:"outcome"`
+	// Delay in seconds, used with OutcomeRetryDelay
+	Delay int `koanf:"delay"`
+
+	from, to int
+}
+
+func (so *StatusOutcome) parse() error {
+	from, to, isRange := strings.Cut(so.Codes, "-")
+	if !isRange {
+		to = from
+	}
+
+	var err error
+	if so.from, err = strconv.Atoi(strings.TrimSpace(from)); err != nil {
+		return fmt.Errorf("%w: invalid status codes %q", ErrConfig, so.Codes)
+	}
+
+	if so.to, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || so.to < so.from {
+		return fmt.Errorf("%w: invalid status codes %q", ErrConfig, so.Codes)
+	}
+
+	if err := so.Outcome.validate(); err != nil {
+		return err
+	}
+
+	if so.Outcome == OutcomeRetryDelay && so.Delay <= 0 {
+		return fmt.Errorf("%w: outcome %q requires positive 'delay'", ErrConfig, so.Outcome)
+	}
+
+	return nil
+}
+
+func (so *StatusOutcome) matches(code int) bool {
+	return code >= so.from && code <= so.to
+}
+
+// lookupOutcome finds the first mapping matching the code
+func lookupOutcome(outcomes []StatusOutcome, code int) (StatusOutcome, bool) {
+	for _, so := range outcomes {
+		if so.matches(code) {
+			return so, true
+		}
+	}
+
+	return StatusOutcome{}, false
+}
@@ -20,25 +20,87 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Burmuley/priority-pubsub/queue"
 	"github.com/Burmuley/priority-pubsub/transform"
 )
 
 var (
-	ErrFail   = errors.New("process failed")
-	ErrFatal  = errors.New("process failed with fatal error")
-	ErrConfig = errors.New("configuration error")
+	ErrFail       = errors.New("process failed")
+	ErrFatal      = errors.New("process failed with fatal error")
+	ErrThrottle   = errors.New("process throttled by subscriber")
+	ErrRetryDelay = errors.New("process failed and should be retried later")
+	ErrDeadLetter = errors.New("process failed and message should be dead-lettered")
+	ErrSkip       = errors.New("message is skipped and should be deleted")
+	ErrConfig     = errors.New("configuration error")
 )
 
+// DelayError is returned when the message should be returned to the queue
+// and stay invisible to consumers for Delay before being retried
+type DelayError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *DelayError) Error() string {
+	return fmt.Sprintf("%s (retry in %s)", e.Err.Error(), e.Delay)
+}
+
+func (e *DelayError) Unwrap() error {
+	return e.Err
+}
+
 type Processor interface {
-	Run(ctx context.Context, msg queue.Message, f transform.TransformationFunc) error
+	Run(ctx context.Context, msg queue.Message, t transform.Transformer) error
+}
+
+// transformMessage returns the message envelope passed through the transformer,
+// the message can not be processed if the transformation fails, so it's dropped
+// unless the transformer asks to dead-letter or to retry it
+func transformMessage(msg queue.Message, t transform.Transformer) (transform.Envelope, error) {
+	env := transform.NewEnvelope(msg)
+	if t == nil {
+		return env, nil
+	}
+
+	env, err := t.Transform(env)
+	if err != nil {
+		// transformers may return the processing result directly, e.g. the filter
+		if isResult(err) {
+			return env, err
+		}
+		if errors.Is(err, transform.ErrDeadLetter) {
+			return env, fmt.Errorf("%w: %w", ErrDeadLetter, err)
+		}
+		if errors.Is(err, transform.ErrRetry) {
+			return env, fmt.Errorf("%w: %w", ErrFail, err)
+		}
+		return env, fmt.Errorf("%w: %w", ErrFatal, err)
+	}
+
+	return env, nil
+}
+
+// isResult reports whether the error already defines what should happen to the message
+func isResult(err error) bool {
+	var delayErr *DelayError
+	return errors.As(err, &delayErr) || errors.Is(err, ErrSkip) || errors.Is(err, ErrFail) ||
+		errors.Is(err, ErrFatal) || errors.Is(err, ErrDeadLetter)
 }
 
 func New(config any) (Processor, error) {
 	switch cfg := config.(type) {
 	case HttpConfig:
 		return NewHttp(cfg)
+	case GrpcConfig:
+		return NewGrpc(cfg)
+	case ExecConfig:
+		return NewExec(cfg)
+	case WorkerConfig:
+		return NewWorker(cfg)
+	case DaprConfig:
+		return NewDapr(cfg)
 	}
 
 	return nil, fmt.Errorf("processor type %T is not supported", config)
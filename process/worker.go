@@ -0,0 +1,303 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package process
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"github.com/Burmuley/priority-pubsub/transform"
+	"io"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	WorkerDefaultWorkers = 1
+	WorkerDefaultTimeout = 120
+)
+
+type WorkerConfig struct {
+	// Command is the executable and its arguments
+	Command []string `koanf:"command"`
+	WorkDir string   `koanf:"work_dir"`
+	// Env is the list of additional "KEY=value" environment variables
+	Env []string `koanf:"env"`
+	// Workers is the number of child processes to keep running
+	Workers int `koanf:"workers"`
+	Timeout int `koanf:"timeout"`
+}
+
+// WorkerRequest is written as a single JSON line to the worker stdin
+type WorkerRequest struct {
+	Id         string            `json:"id"`
+	Queue      string            `json:"queue"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Data is base64 encoded in JSON
+	Data []byte `json:"data"`
+}
+
+// WorkerResponse is read as a single JSON line from the worker stdout
+type WorkerResponse struct {
+	Id      string  `json:"id"`
+	Outcome Outcome `json:"outcome"`
+	// Delay in seconds, used with OutcomeRetryDelay
+	Delay int    `json:"delay,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Worker keeps a pool of long-lived child processes and sends messages to them
+// using JSON lines protocol, each child handles one message at a time
+type Worker struct {
+	config WorkerConfig
+	pool   chan *workerProcess
+	seq    atomic.Uint64
+}
+
+func NewWorker(config WorkerConfig) (*Worker, error) {
+	if len(config.Command) == 0 || config.Command[0] == "" {
+		return nil, fmt.Errorf("%w: field 'command' is mandatory", ErrConfig)
+	}
+
+	if config.Workers == 0 {
+		config.Workers = WorkerDefaultWorkers
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = WorkerDefaultTimeout
+	}
+
+	if config.Workers < 0 || config.Timeout < 0 {
+		return nil, fmt.Errorf("%w: 'workers' and 'timeout' should be positive", ErrConfig)
+	}
+
+	w := &Worker{
+		config: config,
+		pool:   make(chan *workerProcess, config.Workers),
+	}
+
+	for i := 0; i < config.Workers; i++ {
+		wp, err := w.start()
+		if err != nil {
+			return nil, err
+		}
+		w.pool <- wp
+	}
+
+	return w, nil
+}
+
+func (w *Worker) Run(ctx context.Context, msg queue.Message, trans transform.Transformer) error {
+	env, err := transformMessage(msg, trans)
+	if err != nil {
+		return err
+	}
+
+	var wp *workerProcess
+	select {
+	case wp = <-w.pool:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// the slot is returned to the pool even if the child could not be (re)started,
+	// so the next message could try it again
+	defer func() { w.pool <- wp }()
+
+	if wp == nil || wp.exited() {
+		var err error
+		if wp, err = w.start(); err != nil {
+			return fmt.Errorf("%w: %w", ErrFail, err)
+		}
+	}
+
+	// request ID is unique per child, so stale responses could be recognized
+	reqId := fmt.Sprintf("%s-%d", msg.Id(), w.seq.Add(1))
+	line, err := json.Marshal(WorkerRequest{
+		Id:         reqId,
+		Queue:      msg.QueueId(),
+		Attributes: env.Attributes,
+		Data:       env.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrFatal, err)
+	}
+
+	timeout := time.NewTimer(time.Duration(w.config.Timeout) * time.Second)
+	defer timeout.Stop()
+
+	// the write blocks when the child stops reading its stdin, so it's
+	// subject to the timeout as well; killing the child unblocks it
+	written := make(chan error, 1)
+	go func(stdin io.Writer) {
+		_, err := stdin.Write(append(line, '\n'))
+		written <- err
+	}(wp.stdin)
+
+	for {
+		select {
+		case err := <-written:
+			if err != nil {
+				wp.kill()
+				wp = nil
+				return fmt.Errorf("%w: error writing to worker: %w", ErrFail, err)
+			}
+		case resp := <-wp.responses:
+			if resp.Id != reqId {
+				logErr.Printf("worker %d: unexpected response for request %q\n", wp.pid, resp.Id)
+				continue
+			}
+
+			if err := resp.Outcome.validate(); err != nil {
+				return fmt.Errorf("%w: worker %d: %w", ErrFail, wp.pid, err)
+			}
+
+			reason := resp.Error
+			if reason == "" {
+				reason = fmt.Sprintf("worker responded with %q", resp.Outcome)
+			}
+
+			return resp.Outcome.Err(time.Duration(resp.Delay)*time.Second, reason)
+		case <-wp.done:
+			logErr.Printf("worker %d exited unexpectedly, it will be restarted\n", wp.pid)
+			wp = nil
+			return fmt.Errorf("%w: worker exited while processing the message", ErrFail)
+		case <-timeout.C:
+			// the hung child can not be reused as its response may arrive later
+			logErr.Printf("worker %d timed out processing message %q, it will be restarted\n", wp.pid, msg.Id())
+			wp.kill()
+			wp = nil
+			return fmt.Errorf("%w: worker timed out", ErrFail)
+		case <-ctx.Done():
+			wp.kill()
+			wp = nil
+			return ctx.Err()
+		}
+	}
+}
+
+// start launches a new child process and starts reading its responses
+func (w *Worker) start() (*workerProcess, error) {
+	cmd := exec.Command(w.config.Command[0], w.config.Command[1:]...)
+	cmd.Dir = w.config.WorkDir
+	cmd.Env = append(os.Environ(), w.config.Env...)
+	setProcessGroup(cmd)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	wp := &workerProcess{
+		cmd:       cmd,
+		stdin:     stdin,
+		responses: make(chan WorkerResponse, 1),
+		done:      make(chan struct{}),
+	}
+
+	// stderr is copied by our own goroutine started once the pid is known
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = stderrWriter
+
+	err = cmd.Start()
+	stderrWriter.Close()
+	if err != nil {
+		stderrReader.Close()
+		return nil, fmt.Errorf("error starting worker: %w", err)
+	}
+
+	pid := cmd.Process.Pid
+	wp.pid = pid
+	logInfo.Printf("worker %d started\n", pid)
+
+	go func() {
+		defer stderrReader.Close()
+		stderr := &lineLogger{log: func(line string) { logErr.Printf("worker %d stderr: %s\n", pid, line) }}
+		_, _ = io.Copy(stderr, stderrReader)
+		stderr.Flush()
+	}()
+
+	go wp.read(stdout)
+	return wp, nil
+}
+
+type workerProcess struct {
+	cmd       *exec.Cmd
+	pid       int
+	stdin     io.WriteCloser
+	responses chan WorkerResponse
+	done      chan struct{}
+}
+
+// read decodes responses from the child stdout until it exits
+func (wp *workerProcess) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var resp WorkerResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			logErr.Printf("worker %d: invalid response %q: %s\n", wp.pid, scanner.Text(), err)
+			continue
+		}
+
+		// drop the stale response if nobody is waiting for it
+		select {
+		case <-wp.responses:
+		default:
+		}
+		wp.responses <- resp
+	}
+
+	err := wp.cmd.Wait()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		logErr.Printf("worker %d: %s\n", wp.pid, err)
+	}
+
+	logInfo.Printf("worker %d exited with code %d\n", wp.pid, wp.cmd.ProcessState.ExitCode())
+	close(wp.done)
+}
+
+func (wp *workerProcess) exited() bool {
+	select {
+	case <-wp.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (wp *workerProcess) kill() {
+	_ = wp.stdin.Close()
+	if err := killProcessGroup(wp.cmd); err != nil {
+		logErr.Printf("error killing worker %d: %s\n", wp.pid, err)
+	}
+}
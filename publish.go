@@ -0,0 +1,135 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"io"
+	"os"
+	"strings"
+)
+
+// attrFlags collects repeated "-attr name=value" flags
+type attrFlags map[string]string
+
+func (a attrFlags) String() string {
+	pairs := make([]string, 0, len(a))
+	for name, value := range a {
+		pairs = append(pairs, name+"="+value)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (a attrFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("attribute %q should be in form name=value", value)
+	}
+
+	a[name] = val
+	return nil
+}
+
+// runPublish implements the "publish" subcommand which sends a single message
+// to one of the queues defined in the configuration file
+func runPublish(args []string) error {
+	attributes := attrFlags{}
+
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	cfgFlag := fs.String("config", "config.json", "path to the configuration file")
+	queueFlag := fs.String("queue", "", "ID of the queue to publish to, may be omitted if only one queue is configured")
+	dataFlag := fs.String("data", "", "message body")
+	fileFlag := fs.String("file", "", "read message body from the file, \"-\" reads from stdin")
+	fs.Var(attributes, "attr", "message attribute in form name=value, may be repeated")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	data, err := publishData(*dataFlag, *fileFlag)
+	if err != nil {
+		return err
+	}
+
+	kfg, err := loadConfig(*cfgFlag)
+	if err != nil {
+		return err
+	}
+
+	queueConfig, err := getQueueConfigs(kfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queues, err := getQueues(ctx, queueConfig)
+	if err != nil {
+		return err
+	}
+
+	q, err := findQueue(queues, *queueFlag)
+	if err != nil {
+		return err
+	}
+
+	publisher, ok := q.(queue.Publisher)
+	if !ok {
+		return fmt.Errorf("queue %s does not support publishing", q.QueueId())
+	}
+
+	if err := publisher.Publish(ctx, data, attributes); err != nil {
+		return err
+	}
+
+	logInfo.Printf("message published to queue %s\n", q.QueueId())
+	return nil
+}
+
+func publishData(data, fileName string) ([]byte, error) {
+	switch {
+	case data != "" && fileName != "":
+		return nil, fmt.Errorf("flags -data and -file are mutually exclusive")
+	case data != "":
+		return []byte(data), nil
+	case fileName == "" || fileName == "-":
+		return io.ReadAll(os.Stdin)
+	}
+
+	return os.ReadFile(fileName)
+}
+
+func findQueue(queues []queue.Queue, queueId string) (queue.Queue, error) {
+	if queueId == "" {
+		if len(queues) != 1 {
+			return nil, fmt.Errorf("flag -queue is required when more than one queue is configured")
+		}
+		return queues[0], nil
+	}
+
+	for _, q := range queues {
+		if q.QueueId() == queueId {
+			return q, nil
+		}
+	}
+
+	return nil, fmt.Errorf("queue %s is not found in the configuration", queueId)
+}
@@ -23,10 +23,12 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"time"
 )
 
 const (
 	AWSSQSDefaultVisibilityTimeout = 30
+	AWSSQSMaxVisibilityTimeout     = 43200
 )
 
 type AwsSQSConfig struct {
@@ -34,6 +36,8 @@ type AwsSQSConfig struct {
 	VisibilityTimeout int64  `koanf:"visibility_timeout"`
 	Endpoint          string `koanf:"endpoint"`
 	Region            string `koanf:"region"`
+	DeadLetterQueue   string `koanf:"dead_letter_queue"`
+	ReplyQueue        string `koanf:"reply_queue"`
 }
 
 type AwsSQSMessage struct {
@@ -41,6 +45,8 @@ type AwsSQSMessage struct {
 	receiptHandle string
 	queueName     string
 	data          []byte
+	attributes    map[string]string
+	queue         *AwsSQSQueue
 }
 
 func (m AwsSQSMessage) Id() string {
@@ -55,9 +61,43 @@ func (m AwsSQSMessage) Data() []byte {
 	return m.data
 }
 
+func (m AwsSQSMessage) Attributes() map[string]string {
+	return m.attributes
+}
+
+// ExtendLease keeps the message invisible for d starting from now
+func (m *AwsSQSMessage) ExtendLease(d time.Duration) error {
+	return m.queue.ReturnMessageWithDelay(m, d)
+}
+
+// CanReply reports whether the reply queue is configured
+func (m *AwsSQSMessage) CanReply() bool {
+	return m.queue.replyUrl != ""
+}
+
+// Reply sends data to the reply queue with correlation ID set to the message ID
+func (m *AwsSQSMessage) Reply(data []byte, attributes map[string]string) error {
+	if m.queue.replyUrl == "" {
+		return ErrNoReply
+	}
+
+	replyAttributes := map[string]string{CorrelationIdAttribute: m.messageId}
+	for name, value := range attributes {
+		replyAttributes[name] = value
+	}
+
+	if err := m.queue.sendMessage(m.queue.context, m.queue.replyUrl, data, replyAttributes); err != nil {
+		return fmt.Errorf("%w: %w", ErrReplyMsg, err)
+	}
+
+	return nil
+}
+
 type AwsSQSQueue struct {
 	queueName         string
 	queueUrl          string
+	deadLetterUrl     string
+	replyUrl          string
 	session           *session.Session
 	visibilityTimeout int64
 	context           context.Context
@@ -98,6 +138,22 @@ func NewSQSQueue(ctx context.Context, config AwsSQSConfig) (Queue, error) {
 		return nil, err
 	}
 
+	if config.DeadLetterQueue != "" {
+		url, err := q.getQueueUrl(config.DeadLetterQueue)
+		if err != nil {
+			return nil, fmt.Errorf("%w: dead letter queue: %w", ErrNewQueue, err)
+		}
+		q.deadLetterUrl = url
+	}
+
+	if config.ReplyQueue != "" {
+		url, err := q.getQueueUrl(config.ReplyQueue)
+		if err != nil {
+			return nil, fmt.Errorf("%w: reply queue: %w", ErrNewQueue, err)
+		}
+		q.replyUrl = url
+	}
+
 	return q, nil
 }
 
@@ -110,9 +166,10 @@ func (s *AwsSQSQueue) ReceiveMessage() (Message, error) {
 	msgNum := int64(1)
 
 	output, err := svc.ReceiveMessageWithContext(s.context, &sqs.ReceiveMessageInput{
-		MaxNumberOfMessages: &msgNum,
-		QueueUrl:            &s.queueUrl,
-		VisibilityTimeout:   &s.visibilityTimeout,
+		MaxNumberOfMessages:   &msgNum,
+		QueueUrl:              &s.queueUrl,
+		VisibilityTimeout:     &s.visibilityTimeout,
+		MessageAttributeNames: []*string{aws.String(sqs.QueueAttributeNameAll)},
 	})
 
 	if err != nil {
@@ -130,6 +187,15 @@ func (s *AwsSQSQueue) ReceiveMessage() (Message, error) {
 		receiptHandle: *sqsMsg.ReceiptHandle,
 		queueName:     s.queueName,
 		data:          []byte(*sqsMsg.Body),
+		attributes:    make(map[string]string, len(sqsMsg.MessageAttributes)),
+		queue:         s,
+	}
+
+	// binary attributes are not supported
+	for name, attr := range sqsMsg.MessageAttributes {
+		if attr.StringValue != nil {
+			msg.attributes[name] = *attr.StringValue
+		}
 	}
 
 	return msg, nil
@@ -156,13 +222,20 @@ func (s *AwsSQSQueue) DeleteMessage(m Message) error {
 }
 
 func (s *AwsSQSQueue) ReturnMessage(m Message) error {
+	return s.ReturnMessageWithDelay(m, 0)
+}
+
+func (s *AwsSQSQueue) ReturnMessageWithDelay(m Message, delay time.Duration) error {
 	msg, ok := m.(*AwsSQSMessage)
 	if !ok {
 		return errors.New("message should be of type AwsSQSMessage")
 	}
 
 	svc := sqs.New(s.session)
-	visTimeout := int64(0)
+	visTimeout := int64(delay.Seconds())
+	if visTimeout > AWSSQSMaxVisibilityTimeout {
+		visTimeout = AWSSQSMaxVisibilityTimeout
+	}
 
 	_, err := svc.ChangeMessageVisibilityWithContext(s.context, &sqs.ChangeMessageVisibilityInput{
 		QueueUrl:          &s.queueUrl,
@@ -177,14 +250,78 @@ func (s *AwsSQSQueue) ReturnMessage(m Message) error {
 	return nil
 }
 
-func (s *AwsSQSQueue) refreshQueueUrl() error {
+func (s *AwsSQSQueue) DeadLetterMessage(m Message) error {
+	msg, ok := m.(*AwsSQSMessage)
+	if !ok {
+		return errors.New("message should be of type AwsSQSMessage")
+	}
+
+	if s.deadLetterUrl == "" {
+		return ErrNoDeadLetter
+	}
+
+	if err := s.sendMessage(s.context, s.deadLetterUrl, msg.data, msg.attributes); err != nil {
+		return fmt.Errorf("%w: %w", ErrDeadLetterMsg, err)
+	}
+
+	return s.DeleteMessage(m)
+}
+
+// Publish sends a new message to the queue
+func (s *AwsSQSQueue) Publish(ctx context.Context, data []byte, attributes map[string]string) error {
+	if err := s.sendMessage(ctx, s.queueUrl, data, attributes); err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishMsg, err)
+	}
+
+	return nil
+}
+
+func (s *AwsSQSQueue) sendMessage(ctx context.Context, queueUrl string, data []byte, attributes map[string]string) error {
 	svc := sqs.New(s.session)
+	body := string(data)
+
+	_, err := svc.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:          &queueUrl,
+		MessageBody:       &body,
+		MessageAttributes: sqsMessageAttributes(attributes),
+	})
+
+	return err
+}
 
-	output, err := svc.GetQueueUrlWithContext(s.context, &sqs.GetQueueUrlInput{QueueName: &s.queueName})
+func (s *AwsSQSQueue) refreshQueueUrl() error {
+	url, err := s.getQueueUrl(s.queueName)
 	if err != nil {
 		return err
 	}
 
-	s.queueUrl = *output.QueueUrl
+	s.queueUrl = url
 	return nil
 }
+
+func (s *AwsSQSQueue) getQueueUrl(name string) (string, error) {
+	svc := sqs.New(s.session)
+
+	output, err := svc.GetQueueUrlWithContext(s.context, &sqs.GetQueueUrlInput{QueueName: &name})
+	if err != nil {
+		return "", err
+	}
+
+	return *output.QueueUrl, nil
+}
+
+func sqsMessageAttributes(attributes map[string]string) map[string]*sqs.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+
+	result := make(map[string]*sqs.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		result[name] = &sqs.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+
+	return result
+}
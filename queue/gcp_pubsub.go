@@ -23,17 +23,23 @@ import (
 	"fmt"
 	"google.golang.org/api/option"
 	"strings"
+	"sync"
 	"time"
 )
 
 var (
 	GcpPubSubDefaultAckDeadline = 60 * time.Second
+	GcpPubSubMaxAckDeadline     = 600 * time.Second
 )
 
 type GcpPubSubConfig struct {
 	SubscriptionId     string `koanf:"subscription_id"`
 	AckDeadlineSeconds int64  `koanf:"ack_deadline_seconds"`
 	Endpoint           string `koanf:"endpoint"`
+	DeadLetterTopic    string `koanf:"dead_letter_topic"`
+	ReplyTopic         string `koanf:"reply_topic"`
+	// TopicId is where published messages go, defaults to the topic of the subscription
+	TopicId string `koanf:"topic_id"`
 }
 
 type GcpPubSubMessage struct {
@@ -41,6 +47,8 @@ type GcpPubSubMessage struct {
 	subscriptionId string
 	ackId          string
 	data           []byte
+	attributes     map[string]string
+	queue          *GcpPubSubQueue
 }
 
 func (gm GcpPubSubMessage) Id() string {
@@ -55,10 +63,47 @@ func (gm GcpPubSubMessage) Data() []byte {
 	return gm.data
 }
 
+func (gm GcpPubSubMessage) Attributes() map[string]string {
+	return gm.attributes
+}
+
+// ExtendLease sets the message ack deadline to d starting from now
+func (gm *GcpPubSubMessage) ExtendLease(d time.Duration) error {
+	return gm.queue.ReturnMessageWithDelay(gm, d)
+}
+
+// CanReply reports whether the reply topic is configured
+func (gm *GcpPubSubMessage) CanReply() bool {
+	return gm.queue.replyTopic != ""
+}
+
+// Reply publishes data to the reply topic with correlation ID set to the message ID
+func (gm *GcpPubSubMessage) Reply(data []byte, attributes map[string]string) error {
+	if gm.queue.replyTopic == "" {
+		return ErrNoReply
+	}
+
+	replyAttributes := map[string]string{CorrelationIdAttribute: gm.messageId}
+	for name, value := range attributes {
+		replyAttributes[name] = value
+	}
+
+	if err := gm.queue.publish(gm.queue.replyTopic, data, replyAttributes); err != nil {
+		return fmt.Errorf("%w: %w", ErrReplyMsg, err)
+	}
+
+	return nil
+}
+
 type GcpPubSubQueue struct {
 	subscriptionId string
 	projectId      string
 	client         *pubsub.SubscriberClient
+	publisher      *pubsub.PublisherClient
+	deadLetter     string
+	replyTopic     string
+	topicId        string
+	topicMu        sync.Mutex
 	ackDeadline    int64
 	context        context.Context
 }
@@ -90,6 +135,15 @@ func NewGcpPubSubQueue(ctx context.Context, config GcpPubSubConfig) (Queue, erro
 		return nil, fmt.Errorf("%w: %w", ErrNewQueue, err)
 	}
 
+	q.publisher, err = pubsub.NewPublisherClient(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNewQueue, err)
+	}
+
+	q.deadLetter = config.DeadLetterTopic
+	q.replyTopic = config.ReplyTopic
+	q.topicId = config.TopicId
+
 	q.subscriptionId = config.SubscriptionId
 	q.context = ctx
 	return q, nil
@@ -121,6 +175,8 @@ func (gq *GcpPubSubQueue) ReceiveMessage() (Message, error) {
 		messageId:      gcpMsg.Message.MessageId,
 		ackId:          gcpMsg.AckId,
 		data:           gcpMsg.Message.Data,
+		attributes:     gcpMsg.Message.Attributes,
+		queue:          gq,
 	}
 
 	// extend Ack Deadline for the message
@@ -156,15 +212,24 @@ func (gq *GcpPubSubQueue) DeleteMessage(m Message) error {
 }
 
 func (gq *GcpPubSubQueue) ReturnMessage(m Message) error {
+	return gq.ReturnMessageWithDelay(m, 0)
+}
+
+func (gq *GcpPubSubQueue) ReturnMessageWithDelay(m Message, delay time.Duration) error {
 	msg, ok := m.(*GcpPubSubMessage)
 	if !ok {
-		return fmt.Errorf("%w: expected *GcpPubSubMessage object", ErrDeleteMsg)
+		return fmt.Errorf("%w: expected *GcpPubSubMessage object", ErrReturnMsg)
+	}
+
+	if delay > GcpPubSubMaxAckDeadline {
+		delay = GcpPubSubMaxAckDeadline
 	}
 
+	// message is redelivered once its ack deadline expires
 	ackReq := &pubsubpb.ModifyAckDeadlineRequest{
 		Subscription:       gq.subscriptionId,
 		AckIds:             []string{msg.ackId},
-		AckDeadlineSeconds: 0,
+		AckDeadlineSeconds: int32(delay.Seconds()),
 	}
 
 	if err := gq.client.ModifyAckDeadline(gq.context, ackReq); err != nil {
@@ -173,3 +238,65 @@ func (gq *GcpPubSubQueue) ReturnMessage(m Message) error {
 
 	return nil
 }
+
+func (gq *GcpPubSubQueue) DeadLetterMessage(m Message) error {
+	msg, ok := m.(*GcpPubSubMessage)
+	if !ok {
+		return fmt.Errorf("%w: expected *GcpPubSubMessage object", ErrDeadLetterMsg)
+	}
+
+	if gq.deadLetter == "" {
+		return ErrNoDeadLetter
+	}
+
+	if err := gq.publish(gq.deadLetter, msg.data, msg.attributes); err != nil {
+		return fmt.Errorf("%w: %w", ErrDeadLetterMsg, err)
+	}
+
+	return gq.DeleteMessage(m)
+}
+
+// Publish publishes a new message to the topic of the subscription
+func (gq *GcpPubSubQueue) Publish(ctx context.Context, data []byte, attributes map[string]string) error {
+	topic, err := gq.topic(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishMsg, err)
+	}
+
+	if err := gq.publishWithContext(ctx, topic, data, attributes); err != nil {
+		return fmt.Errorf("%w: %w", ErrPublishMsg, err)
+	}
+
+	return nil
+}
+
+// topic returns the configured topic or looks up the one the subscription is attached to
+func (gq *GcpPubSubQueue) topic(ctx context.Context) (string, error) {
+	gq.topicMu.Lock()
+	defer gq.topicMu.Unlock()
+
+	if gq.topicId != "" {
+		return gq.topicId, nil
+	}
+
+	sub, err := gq.client.GetSubscription(ctx, &pubsubpb.GetSubscriptionRequest{Subscription: gq.subscriptionId})
+	if err != nil {
+		return "", err
+	}
+
+	gq.topicId = sub.Topic
+	return gq.topicId, nil
+}
+
+func (gq *GcpPubSubQueue) publish(topic string, data []byte, attributes map[string]string) error {
+	return gq.publishWithContext(gq.context, topic, data, attributes)
+}
+
+func (gq *GcpPubSubQueue) publishWithContext(ctx context.Context, topic string, data []byte, attributes map[string]string) error {
+	_, err := gq.publisher.Publish(ctx, &pubsubpb.PublishRequest{
+		Topic:    topic,
+		Messages: []*pubsubpb.PubsubMessage{{Data: data, Attributes: attributes}},
+	})
+
+	return err
+}
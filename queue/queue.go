@@ -20,21 +20,52 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 )
 
 var (
-	ErrNoMessages = errors.New("no messages received")
-	ErrNewQueue   = errors.New("error creating new queue instance")
-	ErrConfig     = errors.New("queue configuration error")
-	ErrReceiveMsg = errors.New("error when receiving message")
-	ErrReturnMsg  = errors.New("error when returning message")
-	ErrDeleteMsg  = errors.New("error when deleting message")
+	ErrNoMessages    = errors.New("no messages received")
+	ErrNewQueue      = errors.New("error creating new queue instance")
+	ErrConfig        = errors.New("queue configuration error")
+	ErrReceiveMsg    = errors.New("error when receiving message")
+	ErrReturnMsg     = errors.New("error when returning message")
+	ErrDeleteMsg     = errors.New("error when deleting message")
+	ErrDeadLetterMsg = errors.New("error when dead-lettering message")
+	ErrNoDeadLetter  = errors.New("no dead letter destination configured")
+	ErrReplyMsg      = errors.New("error when sending reply")
+	ErrNoReply       = errors.New("no reply destination configured")
+	ErrPublishMsg    = errors.New("error when publishing message")
+)
+
+const (
+	// CorrelationIdAttribute is set on replies to the ID of the original message
+	CorrelationIdAttribute = "correlation_id"
 )
 
 type Message interface {
 	Id() string
 	QueueId() string
 	Data() []byte
+	Attributes() map[string]string
+}
+
+// LeaseExtender is implemented by messages which can stay invisible to
+// other consumers for longer than the queue default
+type LeaseExtender interface {
+	ExtendLease(d time.Duration) error
+}
+
+// Replier is implemented by messages which can be replied to, the reply is sent to
+// the reply destination of the queue the message has been received from
+type Replier interface {
+	// CanReply reports whether the reply destination is configured
+	CanReply() bool
+	Reply(data []byte, attributes map[string]string) error
+}
+
+// Publisher is implemented by queues which messages can be published to
+type Publisher interface {
+	Publish(ctx context.Context, data []byte, attributes map[string]string) error
 }
 
 type Queue interface {
@@ -42,6 +73,8 @@ type Queue interface {
 	ReceiveMessage() (Message, error)
 	DeleteMessage(m Message) error
 	ReturnMessage(m Message) error
+	ReturnMessageWithDelay(m Message, delay time.Duration) error
+	DeadLetterMessage(m Message) error
 }
 
 func New(ctx context.Context, config any) (Queue, error) {
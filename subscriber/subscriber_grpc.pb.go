@@ -0,0 +1,126 @@
+//
+// Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: subscriber.proto
+
+package subscriber
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Subscriber_Deliver_FullMethodName = "/prioritypubsub.subscriber.v1.Subscriber/Deliver"
+)
+
+// SubscriberClient is the client API for Subscriber service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SubscriberClient interface {
+	// Deliver is called once per message, the response status defines what happens to the message
+	Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (*DeliverResponse, error)
+}
+
+type subscriberClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSubscriberClient(cc grpc.ClientConnInterface) SubscriberClient {
+	return &subscriberClient{cc}
+}
+
+func (c *subscriberClient) Deliver(ctx context.Context, in *DeliverRequest, opts ...grpc.CallOption) (*DeliverResponse, error) {
+	out := new(DeliverResponse)
+	err := c.cc.Invoke(ctx, Subscriber_Deliver_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SubscriberServer is the server API for Subscriber service.
+// All implementations must embed UnimplementedSubscriberServer
+// for forward compatibility
+type SubscriberServer interface {
+	// Deliver is called once per message, the response status defines what happens to the message
+	Deliver(context.Context, *DeliverRequest) (*DeliverResponse, error)
+	mustEmbedUnimplementedSubscriberServer()
+}
+
+// UnimplementedSubscriberServer must be embedded to have forward compatible implementations.
+type UnimplementedSubscriberServer struct {
+}
+
+func (UnimplementedSubscriberServer) Deliver(context.Context, *DeliverRequest) (*DeliverResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deliver not implemented")
+}
+func (UnimplementedSubscriberServer) mustEmbedUnimplementedSubscriberServer() {}
+
+// UnsafeSubscriberServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SubscriberServer will
+// result in compilation errors.
+type UnsafeSubscriberServer interface {
+	mustEmbedUnimplementedSubscriberServer()
+}
+
+func RegisterSubscriberServer(s grpc.ServiceRegistrar, srv SubscriberServer) {
+	s.RegisterService(&Subscriber_ServiceDesc, srv)
+}
+
+func _Subscriber_Deliver_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeliverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SubscriberServer).Deliver(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Subscriber_Deliver_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SubscriberServer).Deliver(ctx, req.(*DeliverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Subscriber_ServiceDesc is the grpc.ServiceDesc for Subscriber service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Subscriber_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "prioritypubsub.subscriber.v1.Subscriber",
+	HandlerType: (*SubscriberServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Deliver",
+			Handler:    _Subscriber_Deliver_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "subscriber.proto",
+}
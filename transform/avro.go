@@ -0,0 +1,178 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transform
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/linkedin/goavro/v2"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AvroRegistryTimeout = 10 * time.Second
+
+	// Confluent wire format: magic byte followed by the 4-byte big-endian schema ID
+	avroMagicByte  = 0
+	avroHeaderSize = 5
+)
+
+// AvroConfig is the configuration of the Avro to JSON conversion step, schemas
+// are resolved from the schema registry or from the local directory
+type AvroConfig struct {
+	// RegistryUrl is the base URL of the Confluent compatible schema registry
+	RegistryUrl string `koanf:"registry_url"`
+	// SchemaDir is the directory with schemas stored as "<schema ID>.avsc" files
+	SchemaDir string `koanf:"schema_dir"`
+}
+
+type avro struct {
+	config AvroConfig
+	client *http.Client
+	mu     sync.Mutex
+	codecs map[uint32]*goavro.Codec
+}
+
+func NewAvro(config AvroConfig) (Transformer, error) {
+	if (config.RegistryUrl == "") == (config.SchemaDir == "") {
+		return nil, errors.New("exactly one of parameters 'registry_url' or 'schema_dir' is mandatory")
+	}
+
+	return &avro{
+		config: config,
+		client: &http.Client{Timeout: AvroRegistryTimeout},
+		codecs: make(map[uint32]*goavro.Codec),
+	}, nil
+}
+
+// Transform decodes Confluent framed Avro message data and replaces it with its JSON representation
+func (a *avro) Transform(e Envelope) (Envelope, error) {
+	if len(e.Data) < avroHeaderSize || e.Data[0] != avroMagicByte {
+		return e, errors.New("data is not Confluent framed Avro")
+	}
+
+	schemaId := binary.BigEndian.Uint32(e.Data[1:avroHeaderSize])
+	codec, err := a.codec(schemaId)
+	if err != nil {
+		return e, err
+	}
+
+	native, _, err := codec.NativeFromBinary(e.Data[avroHeaderSize:])
+	if err != nil {
+		return e, fmt.Errorf("error decoding message with schema %d: %w", schemaId, err)
+	}
+
+	data, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		return e, fmt.Errorf("error encoding message with schema %d to JSON: %w", schemaId, err)
+	}
+
+	e.Data = data
+	e.ContentType = "application/json"
+	return e, nil
+}
+
+// codec returns the cached codec of the schema, schemas never change once registered
+func (a *avro) codec(schemaId uint32) (*goavro.Codec, error) {
+	a.mu.Lock()
+	codec, ok := a.codecs[schemaId]
+	a.mu.Unlock()
+	if ok {
+		return codec, nil
+	}
+
+	// the lock is not held while the schema is resolved, so a slow registry does not block
+	// messages with known schemas; concurrent misses may resolve the same schema twice
+	var schema string
+	var err error
+	if a.config.SchemaDir != "" {
+		schema, err = a.localSchema(schemaId)
+	} else {
+		schema, err = a.registrySchema(schemaId)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error resolving schema %d: %w", schemaId, err)
+	}
+
+	// unions are encoded as plain JSON values rather than {"type": value} objects
+	codec, err = goavro.NewCodecForStandardJSONFull(schema)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing schema %d: %w", schemaId, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if cached, ok := a.codecs[schemaId]; ok {
+		return cached, nil
+	}
+
+	a.codecs[schemaId] = codec
+	return codec, nil
+}
+
+func (a *avro) localSchema(schemaId uint32) (string, error) {
+	schema, err := os.ReadFile(filepath.Join(a.config.SchemaDir, fmt.Sprintf("%d.avsc", schemaId)))
+	if err != nil {
+		return "", err
+	}
+
+	return string(schema), nil
+}
+
+// registrySchema fetches the schema from the registry, credentials are taken from the URL if any;
+// messages are retried when the registry is unavailable
+func (a *avro) registrySchema(schemaId uint32) (string, error) {
+	url := fmt.Sprintf("%s/schemas/ids/%d", strings.TrimSuffix(a.config.RegistryUrl, "/"), schemaId)
+	resp, err := a.client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRetry, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return "", fmt.Errorf("%w: schema registry response status code %d", ErrRetry, resp.StatusCode)
+		}
+		return "", fmt.Errorf("schema registry response status code %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Schema     string `json:"schema"`
+		SchemaType string `json:"schemaType"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("error reading schema registry response: %w", err)
+	}
+
+	if body.SchemaType != "" && body.SchemaType != "AVRO" {
+		return "", fmt.Errorf("unsupported schema type %q", body.SchemaType)
+	}
+
+	return body.Schema, nil
+}
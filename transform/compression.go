@@ -0,0 +1,185 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transform
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+)
+
+const (
+	// DecompressDefaultMaxSize limits the decompressed data size
+	DecompressDefaultMaxSize = 64 << 20
+
+	EncodingGzip     = "gzip"
+	EncodingZstd     = "zstd"
+	EncodingSnappy   = "snappy"
+	EncodingIdentity = "identity"
+)
+
+var (
+	gzipMagic         = []byte{0x1f, 0x8b}
+	zstdMagic         = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyStreamMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// Base64Config is the configuration of the base64 decoding step
+type Base64Config struct {
+	// UrlSafe decodes the URL safe alphabet instead of the standard one
+	UrlSafe bool `koanf:"url_safe"`
+}
+
+// NewBase64Decode returns the step decoding base64 data, padding is optional
+func NewBase64Decode(config Base64Config) (Transformer, error) {
+	encoding := base64.RawStdEncoding
+	if config.UrlSafe {
+		encoding = base64.RawURLEncoding
+	}
+
+	return TransformationFunc(func(d []byte) ([]byte, error) {
+		trimmed := strings.TrimRight(strings.TrimSpace(string(d)), "=")
+		data, err := encoding.DecodeString(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("base64 decoding error: %w", err)
+		}
+		return data, nil
+	}), nil
+}
+
+// DecompressConfig is the configuration of the decompression steps
+type DecompressConfig struct {
+	// MaxSize is the maximum decompressed data size in bytes
+	MaxSize int64 `koanf:"max_size"`
+	// EncodingAttribute is the message attribute holding the encoding, used by the
+	// "decompress" step instead of magic bytes detection, e.g. "content_encoding"
+	EncodingAttribute string `koanf:"encoding_attribute"`
+}
+
+// NewDecompress returns the step decompressing data with the given encoding,
+// the "decompress" step detects the encoding and passes uncompressed data as is
+func NewDecompress(encoding string, config DecompressConfig) (Transformer, error) {
+	if config.MaxSize == 0 {
+		config.MaxSize = DecompressDefaultMaxSize
+	}
+
+	if encoding != "" {
+		if config.EncodingAttribute != "" {
+			return nil, errors.New("parameter 'encoding_attribute' is supported by the 'decompress' step only")
+		}
+
+		return TransformationFunc(func(d []byte) ([]byte, error) {
+			return decompress(encoding, d, config.MaxSize)
+		}), nil
+	}
+
+	return EnvelopeFunc(func(e Envelope) (Envelope, error) {
+		encoding := detectEncoding(e.Data)
+		if config.EncodingAttribute != "" {
+			encoding = strings.ToLower(e.Attributes[config.EncodingAttribute])
+		}
+
+		if encoding == "" || encoding == EncodingIdentity {
+			return e, nil
+		}
+
+		data, err := decompress(encoding, e.Data, config.MaxSize)
+		if err != nil {
+			return e, err
+		}
+
+		e.Data = data
+		return e, nil
+	}), nil
+}
+
+// detectEncoding returns the encoding by the magic bytes, snappy is recognized in the framed format only
+func detectEncoding(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		return EncodingGzip
+	case bytes.HasPrefix(data, zstdMagic):
+		return EncodingZstd
+	case bytes.HasPrefix(data, snappyStreamMagic):
+		return EncodingSnappy
+	}
+
+	return ""
+}
+
+func decompress(encoding string, data []byte, maxSize int64) ([]byte, error) {
+	var reader io.Reader
+
+	switch encoding {
+	case EncodingGzip:
+		gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("gzip decompression error: %w", err)
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case EncodingZstd:
+		zstdReader, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("zstd decompression error: %w", err)
+		}
+		defer zstdReader.Close()
+		reader = zstdReader
+	case EncodingSnappy:
+		if !bytes.HasPrefix(data, snappyStreamMagic) {
+			return decodeSnappyBlock(data, maxSize)
+		}
+		reader = snappy.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+
+	result, err := io.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%s decompression error: %w", encoding, err)
+	}
+
+	if int64(len(result)) > maxSize {
+		return nil, fmt.Errorf("decompressed data exceeds %d bytes", maxSize)
+	}
+
+	return result, nil
+}
+
+func decodeSnappyBlock(data []byte, maxSize int64) ([]byte, error) {
+	size, err := snappy.DecodedLen(data)
+	if err != nil {
+		return nil, fmt.Errorf("snappy decompression error: %w", err)
+	}
+
+	if int64(size) > maxSize {
+		return nil, fmt.Errorf("decompressed data exceeds %d bytes", maxSize)
+	}
+
+	result, err := snappy.Decode(nil, data)
+	if err != nil {
+		return nil, fmt.Errorf("snappy decompression error: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/itchyny/gojq"
+)
+
+// JqConfig is the configuration of the jq transformation step
+type JqConfig struct {
+	Expression string `koanf:"expression"`
+	// RawOutput writes string results as is instead of JSON strings, like `jq -r`
+	RawOutput bool `koanf:"raw_output"`
+}
+
+// jqVariables are available in the expression along with the message data passed as input
+var jqVariables = []string{"$attributes", "$queue_id", "$message_id"}
+
+// JqQuery is the compiled jq expression evaluated over the envelope, message attributes,
+// the queue ID and the message ID are available as $attributes, $queue_id and $message_id
+type JqQuery struct {
+	code *gojq.Code
+}
+
+func NewJqQuery(expression string) (*JqQuery, error) {
+	query, err := gojq.Parse(expression)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing jq expression: %w", err)
+	}
+
+	code, err := gojq.Compile(query, gojq.WithVariables(jqVariables))
+	if err != nil {
+		return nil, fmt.Errorf("error compiling jq expression: %w", err)
+	}
+
+	return &JqQuery{code: code}, nil
+}
+
+// Run evaluates the query over the input with the envelope metadata and returns
+// the first result, false is returned when the expression produced no output
+func (q *JqQuery) Run(input any, e Envelope) (any, bool, error) {
+	attributes := make(map[string]any, len(e.Attributes))
+	for name, value := range e.Attributes {
+		attributes[name] = value
+	}
+
+	result, ok := q.code.Run(input, attributes, e.QueueId, e.MessageId).Next()
+	if !ok {
+		return nil, false, nil
+	}
+
+	if err, ok := result.(error); ok {
+		return nil, false, fmt.Errorf("jq expression error: %w", err)
+	}
+
+	return result, true, nil
+}
+
+// DecodeJson decodes the JSON data to be passed to the query, numbers are kept
+// as json.Number, so big integers are not rounded
+func DecodeJson(data []byte) (any, error) {
+	var input any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&input); err != nil {
+		return nil, err
+	}
+
+	return input, nil
+}
+
+type jq struct {
+	config JqConfig
+	query  *JqQuery
+}
+
+func NewJq(config JqConfig) (Transformer, error) {
+	if config.Expression == "" {
+		return nil, errors.New("parameter 'expression' is mandatory")
+	}
+
+	query, err := NewJqQuery(config.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &jq{config: config, query: query}, nil
+}
+
+// Transform runs the expression over the JSON message data, only the first result is used
+func (j *jq) Transform(e Envelope) (Envelope, error) {
+	input, err := DecodeJson(e.Data)
+	if err != nil {
+		return e, fmt.Errorf("data transformation error: %w", err)
+	}
+
+	result, ok, err := j.query.Run(input, e)
+	if err != nil {
+		return e, err
+	}
+
+	if !ok {
+		return e, errors.New("jq expression produced no output")
+	}
+
+	if s, ok := result.(string); ok && j.config.RawOutput {
+		e.Data = []byte(s)
+		return e, nil
+	}
+
+	data, err := gojq.Marshal(result)
+	if err != nil {
+		return e, fmt.Errorf("data transformation error: %w", err)
+	}
+
+	e.Data = data
+	return e, nil
+}
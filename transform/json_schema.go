@@ -0,0 +1,109 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"strings"
+)
+
+const (
+	JsonSchemaOnInvalidFatal      = "fatal"
+	JsonSchemaOnInvalidDeadLetter = "dead_letter"
+)
+
+// JsonSchemaConfig is the configuration of the JSON Schema validation step
+type JsonSchemaConfig struct {
+	SchemaFile string `koanf:"schema_file"`
+	// OnInvalid is "fatal" (default) to drop invalid messages or "dead_letter" to dead-letter them
+	OnInvalid string `koanf:"on_invalid"`
+}
+
+type jsonSchema struct {
+	config JsonSchemaConfig
+	schema *jsonschema.Schema
+}
+
+func NewJsonSchema(config JsonSchemaConfig) (Transformer, error) {
+	if config.SchemaFile == "" {
+		return nil, errors.New("parameter 'schema_file' is mandatory")
+	}
+
+	if config.OnInvalid == "" {
+		config.OnInvalid = JsonSchemaOnInvalidFatal
+	}
+
+	if config.OnInvalid != JsonSchemaOnInvalidFatal && config.OnInvalid != JsonSchemaOnInvalidDeadLetter {
+		return nil, fmt.Errorf("unsupported 'on_invalid' value %q", config.OnInvalid)
+	}
+
+	schema, err := jsonschema.Compile(config.SchemaFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading schema: %w", err)
+	}
+
+	return &jsonSchema{config: config, schema: schema}, nil
+}
+
+// Transform validates the message data, the envelope is returned unchanged
+func (j *jsonSchema) Transform(e Envelope) (Envelope, error) {
+	var payload any
+	decoder := json.NewDecoder(bytes.NewReader(e.Data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return e, j.invalid(fmt.Sprintf("invalid JSON: %s", err))
+	}
+
+	if err := j.schema.Validate(payload); err != nil {
+		var validationErr *jsonschema.ValidationError
+		if errors.As(err, &validationErr) {
+			return e, j.invalid(strings.Join(validationMessages(validationErr, nil), "; "))
+		}
+		return e, err
+	}
+
+	return e, nil
+}
+
+func (j *jsonSchema) invalid(reason string) error {
+	if j.config.OnInvalid == JsonSchemaOnInvalidDeadLetter {
+		return fmt.Errorf("%w: schema validation failed: %s", ErrDeadLetter, reason)
+	}
+
+	return fmt.Errorf("schema validation failed: %s", reason)
+}
+
+// validationMessages flattens the validation error tree into messages of the leaf errors
+func validationMessages(err *jsonschema.ValidationError, messages []string) []string {
+	if len(err.Causes) == 0 {
+		location := err.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return append(messages, fmt.Sprintf("%s: %s", location, err.Message))
+	}
+
+	for _, cause := range err.Causes {
+		messages = validationMessages(cause, messages)
+	}
+
+	return messages
+}
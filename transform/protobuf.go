@@ -0,0 +1,138 @@
+/*
+ * Copyright 2023. Konstantin Vasilev (burmuley@gmail.com)
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package transform
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"os"
+	"strings"
+)
+
+// ProtobufJsonConfig is the configuration of the protobuf to JSON conversion step
+type ProtobufJsonConfig struct {
+	// DescriptorSetFile is the FileDescriptorSet, e.g. produced by `protoc --include_imports --descriptor_set_out`
+	DescriptorSetFile string `koanf:"descriptor_set_file"`
+	// MessageType is the full name of the message type, used when TypeAttribute is not set on the message
+	MessageType string `koanf:"message_type"`
+	// TypeAttribute is the message attribute holding the full name of the message type
+	TypeAttribute   string `koanf:"type_attribute"`
+	UseProtoNames   bool   `koanf:"use_proto_names"`
+	EmitUnpopulated bool   `koanf:"emit_unpopulated"`
+}
+
+type protobufJson struct {
+	config  ProtobufJsonConfig
+	types   *dynamicpb.Types
+	marshal protojson.MarshalOptions
+}
+
+func NewProtobufJson(config ProtobufJsonConfig) (Transformer, error) {
+	if config.DescriptorSetFile == "" {
+		return nil, errors.New("parameter 'descriptor_set_file' is mandatory")
+	}
+
+	if config.MessageType == "" && config.TypeAttribute == "" {
+		return nil, errors.New("one of parameters 'message_type' or 'type_attribute' is mandatory")
+	}
+
+	raw, err := os.ReadFile(config.DescriptorSetFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading descriptor set: %w", err)
+	}
+
+	descriptorSet := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(raw, descriptorSet); err != nil {
+		return nil, fmt.Errorf("error parsing descriptor set: %w", err)
+	}
+
+	files, err := protodesc.NewFiles(descriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing descriptor set: %w", err)
+	}
+
+	p := &protobufJson{
+		config: config,
+		types:  dynamicpb.NewTypes(files),
+	}
+
+	if config.MessageType != "" {
+		if _, err := p.messageType(config.MessageType); err != nil {
+			return nil, err
+		}
+	}
+
+	p.marshal = protojson.MarshalOptions{
+		UseProtoNames:   config.UseProtoNames,
+		EmitUnpopulated: config.EmitUnpopulated,
+		Resolver:        p.types,
+	}
+
+	return p, nil
+}
+
+// Transform decodes the binary protobuf message data and replaces it with its JSON representation
+func (p *protobufJson) Transform(e Envelope) (Envelope, error) {
+	typeName := p.config.MessageType
+	if value, ok := e.Attributes[p.config.TypeAttribute]; ok && p.config.TypeAttribute != "" {
+		typeName = value
+	}
+
+	if typeName == "" {
+		return e, fmt.Errorf("message type attribute %q is missing", p.config.TypeAttribute)
+	}
+
+	messageType, err := p.messageType(typeName)
+	if err != nil {
+		return e, err
+	}
+
+	msg := messageType.New().Interface()
+	if err := proto.Unmarshal(e.Data, msg); err != nil {
+		return e, fmt.Errorf("error decoding %s message: %w", typeName, err)
+	}
+
+	data, err := p.marshal.Marshal(msg)
+	if err != nil {
+		return e, fmt.Errorf("error encoding %s message to JSON: %w", typeName, err)
+	}
+
+	e.Data = data
+	e.ContentType = "application/json"
+	return e, nil
+}
+
+// messageType looks up the message type by its full name, type URLs like
+// "type.googleapis.com/package.Message" are accepted as well
+func (p *protobufJson) messageType(name string) (protoreflect.MessageType, error) {
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+
+	messageType, err := p.types.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("message type %q is not found in the descriptor set: %w", name, err)
+	}
+
+	return messageType, nil
+}
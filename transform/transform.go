@@ -17,22 +17,212 @@
 package transform
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Burmuley/priority-pubsub/queue"
+	"github.com/mitchellh/mapstructure"
 )
 
+var (
+	ErrConfig = errors.New("transformer configuration error")
+	// ErrDeadLetter is returned by transformers when the message should be dead-lettered instead of dropped
+	ErrDeadLetter = errors.New("message should be dead-lettered")
+	// ErrRetry is returned by transformers on temporary failures, when the message should be returned to the queue
+	ErrRetry = errors.New("transformation should be retried")
+)
+
+// Config describes a single transformation step, step specific parameters are collected into Params
 type Config struct {
-	Type string `koanf:"type"`
+	Type   string         `koanf:"type"`
+	Params map[string]any `koanf:",remain"`
+}
+
+// Envelope is the message data with metadata passed through the transformation steps
+type Envelope struct {
+	MessageId  string
+	QueueId    string
+	Data       []byte
+	Attributes map[string]string
+	// ContentType overrides the content type configured for the processor
+	ContentType string
+	// Headers are added to the request by the processors sending HTTP requests
+	Headers map[string]string
+}
+
+// NewEnvelope returns the envelope of the message, attributes are copied,
+// so transformers could modify them
+func NewEnvelope(msg queue.Message) Envelope {
+	attributes := make(map[string]string, len(msg.Attributes()))
+	for name, value := range msg.Attributes() {
+		attributes[name] = value
+	}
+
+	return Envelope{
+		MessageId:  msg.Id(),
+		QueueId:    msg.QueueId(),
+		Data:       msg.Data(),
+		Attributes: attributes,
+	}
+}
+
+// Transformer transforms the message envelope
+type Transformer interface {
+	Transform(e Envelope) (Envelope, error)
 }
 
+// TransformationFunc transforms only the message data
 type TransformationFunc func(d []byte) ([]byte, error)
 
-func New(fn string) (TransformationFunc, error) {
-	switch fn {
-	case "dapr_aws":
-		return DaprAws, nil
-	case "":
+// Transform adapts byte-only functions to the Transformer interface
+func (f TransformationFunc) Transform(e Envelope) (Envelope, error) {
+	data, err := f(e.Data)
+	if err != nil {
+		return e, err
+	}
+
+	e.Data = data
+	return e, nil
+}
+
+// EnvelopeFunc transforms the whole message envelope
+type EnvelopeFunc func(e Envelope) (Envelope, error)
+
+func (f EnvelopeFunc) Transform(e Envelope) (Envelope, error) {
+	return f(e)
+}
+
+// ParseConfig reads the list of steps from the raw configuration value which may be
+// a single step object, a list of step names or a list of step objects
+func ParseConfig(raw any) ([]Config, error) {
+	var items []any
+	switch value := raw.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		items = value
+	default:
+		items = []any{value}
+	}
+
+	steps := make([]Config, 0, len(items))
+	for i, item := range items {
+		var step Config
+		switch value := item.(type) {
+		case string:
+			step.Type = value
+		case map[string]any:
+			if err := decode(value, &step); err != nil {
+				return nil, fmt.Errorf("%w: step %d: %w", ErrConfig, i+1, err)
+			}
+		default:
+			return nil, fmt.Errorf("%w: step %d: unexpected value %v", ErrConfig, i+1, item)
+		}
+
+		// keeps `"transformer": {"type": ""}` meaning no transformation
+		if step.Type == "" && len(items) == 1 {
+			return nil, nil
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// New builds the pipeline of transformation steps, output of each step is passed to the next one
+func New(steps []Config) (Transformer, error) {
+	if len(steps) == 0 {
 		return nil, nil
 	}
 
-	return nil, fmt.Errorf("invalid transformation function requested: %s", fn)
+	transformers := make([]Transformer, 0, len(steps))
+	for i, step := range steps {
+		t, err := newStep(step)
+		if err != nil {
+			return nil, fmt.Errorf("%w: step %d (%s): %w", ErrConfig, i+1, step.Type, err)
+		}
+		transformers = append(transformers, t)
+	}
+
+	return EnvelopeFunc(func(e Envelope) (Envelope, error) {
+		var err error
+		for i, t := range transformers {
+			if e, err = t.Transform(e); err != nil {
+				return e, fmt.Errorf("transformation step %d (%s) failed: %w", i+1, steps[i].Type, err)
+			}
+		}
+
+		return e, nil
+	}), nil
+}
+
+// decompressSteps maps decompression steps to their encodings, "decompress" detects the encoding
+var decompressSteps = map[string]string{
+	"gunzip":            EncodingGzip,
+	"zstd_decompress":   EncodingZstd,
+	"snappy_decompress": EncodingSnappy,
+	"decompress":        "",
+}
+
+func newStep(config Config) (Transformer, error) {
+	switch config.Type {
+	case "dapr_aws":
+		if err := decode(config.Params, &struct{}{}); err != nil {
+			return nil, err
+		}
+		return TransformationFunc(DaprAws), nil
+	case "jq":
+		jqConfig := JqConfig{}
+		if err := decode(config.Params, &jqConfig); err != nil {
+			return nil, err
+		}
+		return NewJq(jqConfig)
+	case "json_schema":
+		schemaConfig := JsonSchemaConfig{}
+		if err := decode(config.Params, &schemaConfig); err != nil {
+			return nil, err
+		}
+		return NewJsonSchema(schemaConfig)
+	case "protobuf_json":
+		protoConfig := ProtobufJsonConfig{}
+		if err := decode(config.Params, &protoConfig); err != nil {
+			return nil, err
+		}
+		return NewProtobufJson(protoConfig)
+	case "avro":
+		avroConfig := AvroConfig{}
+		if err := decode(config.Params, &avroConfig); err != nil {
+			return nil, err
+		}
+		return NewAvro(avroConfig)
+	case "base64_decode":
+		base64Config := Base64Config{}
+		if err := decode(config.Params, &base64Config); err != nil {
+			return nil, err
+		}
+		return NewBase64Decode(base64Config)
+	case "gunzip", "zstd_decompress", "snappy_decompress", "decompress":
+		decompressConfig := DecompressConfig{}
+		if err := decode(config.Params, &decompressConfig); err != nil {
+			return nil, err
+		}
+		return NewDecompress(decompressSteps[config.Type], decompressConfig)
+	}
+
+	return nil, fmt.Errorf("invalid transformation function requested: %s", config.Type)
+}
+
+// decode decodes the step parameters into the target structure, unknown parameters are rejected
+func decode(params map[string]any, target any) error {
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:          "koanf",
+		WeaklyTypedInput: true,
+		ErrorUnused:      true,
+		Result:           target,
+	})
+	if err != nil {
+		return err
+	}
+
+	return decoder.Decode(params)
 }